@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
 	"github.com/xuri/excelize/v2"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -28,13 +31,13 @@ func useCliPkg() {
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "excel",
-				Usage:       "path to the excel file",
+				Usage:       "path to the excel file, a .csv or .tsv file, or a .json or .yaml request manifest",
 				Required:    true,
 				Destination: &excelFile,
 			},
 			&cli.StringFlag{
 				Name:        "sheet",
-				Usage:       "sheet name",
+				Usage:       "sheet name, a comma separated list of names or globs, or * for all sheets",
 				Destination: &sheetName,
 				DefaultText: "Sheet1",
 			},
@@ -65,11 +68,56 @@ func useCliPkg() {
 						Name:  "unzip-off",
 						Usage: "no unzip",
 					},
+					&cli.StringFlag{
+						Name:  "resume",
+						Usage: "resume a failed run from its run `MANIFEST` in the output dir",
+					},
+					&cli.BoolFlag{
+						Name:  "keep-partial",
+						Usage: "keep the staging dir of a failed run for debugging",
+					},
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "read every split back and compare it with the source files",
+					},
+					&cli.IntFlag{
+						Name:        "workers",
+						Usage:       "number of split zips built in parallel",
+						DefaultText: "zip-package-workers in config, or number of CPUs",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					start := time.Now()
+					ok := pkg(c.String("pdf-dir"), outDir, excelFile, configFile, sheetName, !c.Bool("unzip-off"), c.Int("workers"), c.Bool("keep-partial"), c.String("resume"), c.Bool("verify"))
+					fmt.Printf("Duration: %v\n", time.Since(start))
+					if !ok {
+						return cli.Exit("Package failed", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "validate",
+				Usage: "check the spreadsheet against the source files before packaging",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "pdf-dir",
+						Usage:       "path to `PDF` files",
+						DefaultText: "sources",
+					},
+					&cli.StringFlag{
+						Name:        "report",
+						Usage:       "path to the validate report, .xlsx or .csv",
+						DefaultText: "validate-result--<sheet>.xlsx in output dir",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					start := time.Now()
-					pkg(c.String("pdf-dir"), outDir, excelFile, configFile, sheetName, !c.Bool("unzip-off"))
+					ok := validate(c.String("pdf-dir"), c.String("report"), outDir, excelFile, configFile, sheetName)
 					fmt.Printf("Duration: %v\n", time.Since(start))
+					if !ok {
+						return cli.Exit("Validate found blocking issues", 1)
+					}
 					return nil
 				},
 			},
@@ -134,7 +182,7 @@ func parseArgManually() {
 	}
 	start := time.Now()
 	if cmd == "package" {
-		pkg(fileDir, outDir, xls, config, sheetName, unzip)
+		pkg(fileDir, outDir, xls, config, sheetName, unzip, 0, false, "", false)
 		duration := time.Since(start)
 		fmt.Printf("Duration: %v\n", duration)
 	} else if cmd == "reconcile" {
@@ -147,37 +195,103 @@ func parseArgManually() {
 	}
 }
 
-func pkg(srcDir, outDir, xls, config, sheetName string, unzip bool) {
+func pkg(srcDir, outDir, xls, config, sheetName string, unzip bool, workers int, keepPartial bool, resume string, verify bool) bool {
 	fmt.Printf("Package: %s %s %s %s %s\n", srcDir, outDir, xls, config, sheetName)
 	cfg := loadConfig(config)
 	pi := service.NewParseInstruction()
-	pi.SheetName = sheetName
-	configParseInstructure(pi, cfg)
-	pkg, err := pi.ParsePackageRequests(xls)
-	if err != nil || pkg == nil {
+	if err := configParseInstructure(pi, cfg); err != nil {
+		fmt.Printf("Config failed: %v\n", err)
+		return false
+	}
+	sets, err := pi.ParseSheetSets(xls, sheetName)
+	if err != nil {
 		fmt.Printf("ParsePackageExcel failed or no requests at all: %v\n", err)
-		return
+		return false
 	}
-	fmt.Println("Parse success and get requests: ", len(pkg.Requests))
-	zi := service.NewZipInstruction()
-	zi.SrcDir = srcDir
-	zi.DstDir = outDir
-	zi.Unzip = unzip
-	configZipInstructure(zi, cfg)
-	err = zi.Zip(&(pkg.Requests))
+	if resume != "" && len(sets) > 1 {
+		fmt.Println("Resume takes the sheet of the failed run, not ", len(sets), " sheets")
+		return false
+	}
+	source, err := configSource(srcDir, cfg)
 	if err != nil {
-		fmt.Printf("Zip failed: %v\n", err)
-		return
+		fmt.Printf("Source failed: %v\n", err)
+		return false
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	for _, set := range sets {
+		fmt.Println("Parse success and get requests: ", set.Label, len(set.Pkg.Requests))
+		zi := service.NewZipInstruction()
+		zi.SrcDir = srcDir
+		zi.DstDir = sheetOutDir(outDir, set)
+		zi.Unzip = unzip
+		zi.SheetName = set.Label
+		zi.ExcelName = strings.TrimSuffix(filepath.Base(xls), filepath.Ext(xls))
+		if err = configZipInstructure(zi, cfg); err != nil {
+			fmt.Printf("Config failed: %v\n", err)
+			return false
+		}
+		if source != nil {
+			zi.Source = source
+		}
+		if workers > 0 {
+			zi.Workers = workers
+		}
+		if keepPartial {
+			zi.KeepPartial = true
+		}
+		if verify {
+			zi.Verify = true
+		}
+		if resume != "" {
+			fmt.Println("Resume from: ", resume)
+			_, err = zi.Resume(&(set.Pkg.Requests), resume)
+		} else {
+			_, err = zi.Zip(&(set.Pkg.Requests))
+		}
+		if err != nil {
+			fmt.Printf("Zip failed: %v\n", err)
+			return false
+		}
 	}
 	fmt.Println("Zip success")
+	return true
+}
+
+// sheetOutDir is the output dir of a sheet set, a sub dir named by the sheet when each sheet has its own output
+func sheetOutDir(outDir string, set service.SheetSet) string {
+	if set.SubDir == "" {
+		return outDir
+	}
+	return outDir + "/" + set.SubDir
 }
 
-func configZipInstructure(pi *service.ZipInstruction, cfg *map[string]string) {
+// configZipInstructure fails on a value it cannot parse, when dropping it would change the packages
+func configZipInstructure(pi *service.ZipInstruction, cfg *map[string]string) error {
 	//obtain zip instruction info from config to set the following values
 	//zi.MaxSize = 980 * 1024 * 1024
 	//zi.TargetFileNamePattern = "package-${yyMMddHHmmssSSS}-${splitSeq}"
 	//zi.SourceID = "0086"
 	//zi.MetaXmlFileName = "package-metadata.xml"
+	//zi.Workers = 4
+	//zi.SplitStrategy = "sequential"
+	//zi.MeasureCompressed = false
+	//zi.OversizePolicy = "fail"
+	//zi.KeepPartial = false
+	//zi.ChecksumAlgorithm = "SHA-256"
+	//zi.Sidecar = false
+	//zi.Compression = service.NewCompressionPolicy()
+	//zi.Zip64 = true
+	//zi.Encryption = "none"
+	//zi.ArchiveFormat = "zip"
+	//zi.UnzipMode = "copy"
+	//zi.Verify = false
+	//zi.SourceLookup = "path"
+	//zi.PreserveSubpath = true
+	//zi.DuplicatePolicy = "error"
 	maxSize, ok10 := (*cfg)["zip-package-max-size"]
 	if ok10 {
 		unit := 1024 * 1024 // 1MB
@@ -201,10 +315,11 @@ func configZipInstructure(pi *service.ZipInstruction, cfg *map[string]string) {
 			suffixLen = 1
 		}
 		maxSize = maxSize[:len(maxSize)-suffixLen]
-		maxSizeInt, err := strconv.Atoi(maxSize)
-		if err == nil {
-			pi.MaxSize = int64(maxSizeInt * unit)
+		maxSizeInt, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil {
+			return errors.New("max size [" + (*cfg)["zip-package-max-size"] + "] is not a size like 980MB")
 		}
+		pi.MaxSize = maxSizeInt * int64(unit)
 	}
 	targetFileNamePattern, ok20 := (*cfg)["zip-package-target-file-name-pattern"]
 	if ok20 {
@@ -218,9 +333,152 @@ func configZipInstructure(pi *service.ZipInstruction, cfg *map[string]string) {
 	if ok40 {
 		pi.MetaXmlFileName = metaXmlFileName
 	}
+	workers, ok50 := (*cfg)["zip-package-workers"]
+	if ok50 {
+		workersInt, err := strconv.Atoi(workers)
+		if err != nil || workersInt < 1 {
+			return errors.New("workers [" + workers + "] is not a positive number")
+		}
+		pi.Workers = workersInt
+	}
+	splitStrategy, ok60 := (*cfg)["zip-package-split-strategy"]
+	if ok60 {
+		pi.SplitStrategy = splitStrategy
+	}
+	measureCompressed, ok70 := (*cfg)["zip-package-measure-compressed"]
+	if ok70 {
+		pi.MeasureCompressed = measureCompressed == "true"
+	}
+	oversizePolicy, ok80 := (*cfg)["zip-package-oversize-policy"]
+	if ok80 {
+		pi.OversizePolicy = oversizePolicy
+	}
+	keepPartial, ok90 := (*cfg)["zip-package-keep-partial"]
+	if ok90 {
+		pi.KeepPartial = keepPartial == "true"
+	}
+	checksumAlgorithm, ok100 := (*cfg)["zip-package-checksum-algorithm"]
+	if ok100 {
+		pi.ChecksumAlgorithm = checksumAlgorithm
+	}
+	sidecar, ok110 := (*cfg)["zip-package-sidecar"]
+	if ok110 {
+		pi.Sidecar = sidecar == "true"
+	}
+	compression, ok120 := (*cfg)["zip-package-compression"]
+	if ok120 {
+		method, err := service.ParseCompressionMethod(compression)
+		if err != nil {
+			return err
+		}
+		pi.Compression.Method = method
+	}
+	compressionLevel, ok130 := (*cfg)["zip-package-compression-level"]
+	if ok130 {
+		level, err := strconv.Atoi(compressionLevel)
+		if err != nil {
+			return errors.New("compression level [" + compressionLevel + "] is not a number")
+		}
+		pi.Compression.Level = level
+	}
+	compressionOverrides, ok140 := (*cfg)["zip-package-compression-overrides"]
+	if ok140 {
+		overrides, err := service.ParseCompressionOverrides(compressionOverrides)
+		if err != nil {
+			return err
+		}
+		pi.Compression.Overrides = overrides
+	}
+	zip64, ok150 := (*cfg)["zip64"]
+	if ok150 {
+		pi.Zip64 = zip64 != "false"
+	}
+	encryption, ok160 := (*cfg)["zip-package-encryption"]
+	if ok160 {
+		pi.Encryption = strings.ToLower(encryption)
+	}
+	passwordEnv, ok170 := (*cfg)["zip-package-password-env"]
+	passwordFile, ok180 := (*cfg)["zip-package-password-file"]
+	if pi.Encryption != service.EncryptionNone && (ok170 || ok180) {
+		password, err := service.ResolvePassword(passwordEnv, passwordFile)
+		if err != nil {
+			return err
+		}
+		pi.EncryptionPassword = password
+	}
+	archiveFormat, ok190 := (*cfg)["zip-package-archive-format"]
+	if ok190 {
+		pi.ArchiveFormat = strings.ToLower(archiveFormat)
+	}
+	unzipMode, ok200 := (*cfg)["zip-package-unzip-mode"]
+	if ok200 {
+		pi.UnzipMode = strings.ToLower(unzipMode)
+	}
+	verify, ok210 := (*cfg)["zip-package-verify"]
+	if ok210 {
+		pi.Verify = verify == "true"
+	}
+	sourceLookup, ok220 := (*cfg)["zip-package-source-lookup"]
+	if ok220 {
+		pi.SourceLookup = strings.ToLower(sourceLookup)
+	}
+	preserveSubpath, ok230 := (*cfg)["zip-package-preserve-subpath"]
+	if ok230 {
+		pi.PreserveSubpath = preserveSubpath != "false"
+	}
+	duplicatePolicy, ok240 := (*cfg)["zip-package-duplicate-policy"]
+	if ok240 {
+		pi.DuplicatePolicy = strings.ToLower(duplicatePolicy)
+	}
+	return nil
+}
+
+// configSource returns nil to read from the source dir only. More dirs are looked up after the source dir,
+// while a zip or an S3 bucket replaces it
+func configSource(srcDir string, cfg *map[string]string) (service.SourceResolver, error) {
+	sourceZip, ok10 := (*cfg)["zip-package-source-zip"]
+	s3Endpoint, ok20 := (*cfg)["zip-package-source-s3-endpoint"]
+	sourceDirs, ok30 := (*cfg)["zip-package-source-dirs"]
+	if ok10 && ok20 {
+		return nil, errors.New("zip-package-source-zip and zip-package-source-s3-endpoint exclude each other")
+	}
+	if ok10 {
+		return service.NewZipArchiveSource(sourceZip)
+	}
+	if ok20 {
+		s3Cfg := service.S3Config{
+			Endpoint: s3Endpoint,
+			Region:   (*cfg)["zip-package-source-s3-region"],
+			UseSSL:   (*cfg)["zip-package-source-s3-use-ssl"] != "false",
+			Bucket:   (*cfg)["zip-package-source-s3-bucket"],
+			Prefix:   (*cfg)["zip-package-source-s3-prefix"],
+		}
+		accessKeyEnv, ok := (*cfg)["zip-package-source-s3-access-key-env"]
+		if !ok {
+			accessKeyEnv = "AWS_ACCESS_KEY_ID"
+		}
+		secretKeyEnv, ok := (*cfg)["zip-package-source-s3-secret-key-env"]
+		if !ok {
+			secretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+		}
+		s3Cfg.AccessKey = os.Getenv(accessKeyEnv)
+		s3Cfg.SecretKey = os.Getenv(secretKeyEnv)
+		return service.NewS3Source(s3Cfg)
+	}
+	if ok30 {
+		dirs := []string{srcDir}
+		for _, dir := range strings.Split(sourceDirs, ",") {
+			if strings.TrimSpace(dir) != "" {
+				dirs = append(dirs, strings.TrimSpace(dir))
+			}
+		}
+		return service.NewMultiDirSource(dirs...), nil
+	}
+	return nil, nil
 }
 
-func configParseInstructure(pi *service.ParseInstruction, cfg *map[string]string) {
+// configParseInstructure fails on a value it cannot parse, like configZipInstructure
+func configParseInstructure(pi *service.ParseInstruction, cfg *map[string]string) error {
 	//obtain parse instruction info from config to set the following values
 	//pi.SetGroupNameDelimiter("[", "]")
 	//pi.SetGroupIdNameDelimiter(":")
@@ -249,32 +507,167 @@ func configParseInstructure(pi *service.ParseInstruction, cfg *map[string]string
 			pi.SetContinuousEmptyRowLimit(int8(emptyRowLimitInt))
 		}
 	}
+	csvDelimiter, ok60 := (*cfg)["csv-delimiter"]
+	if ok60 {
+		pi.CsvDelimiter = csvDelimiter
+	}
+	csvQuoting, ok70 := (*cfg)["csv-quoting"]
+	if ok70 {
+		pi.CsvQuoting = csvQuoting
+	}
+	csvEncoding, ok80 := (*cfg)["csv-encoding"]
+	if ok80 {
+		pi.CsvEncoding = csvEncoding
+	}
+	sheetOutput, ok90 := (*cfg)["sheet-output"]
+	if ok90 {
+		pi.SheetOutput = sheetOutput
+	}
+	headerRowOffset, ok100 := (*cfg)["header-row-offset"]
+	if ok100 {
+		headerRowOffsetInt, err := strconv.Atoi(headerRowOffset)
+		if err != nil {
+			return errors.New("header row offset [" + headerRowOffset + "] is not a number")
+		}
+		pi.HeaderRowOffset = headerRowOffsetInt
+	}
+	headerRowAuto, ok110 := (*cfg)["header-row-auto"]
+	if ok110 {
+		pi.HeaderRowAuto = headerRowAuto == "true"
+	}
+	twoRowHeader, ok120 := (*cfg)["two-row-header"]
+	if ok120 {
+		pi.TwoRowHeader = twoRowHeader == "true"
+	}
+	return nil
+}
+
+func validate(srcDir, reportFile, outDir, xls, config, sheetName string) bool {
+	fmt.Printf("Validate: %s %s %s %s %s\n", srcDir, outDir, xls, config, sheetName)
+	cfg := loadConfig(config)
+	pi := service.NewParseInstruction()
+	if err := configParseInstructure(pi, cfg); err != nil {
+		fmt.Printf("Config failed: %v\n", err)
+		return false
+	}
+	sets, err := pi.ParseSheetSets(xls, sheetName)
+	if err != nil {
+		fmt.Printf("ParsePackageExcel failed or no requests at all: %v\n", err)
+		return false
+	}
+	source, err := configSource(srcDir, cfg)
+	if err != nil {
+		fmt.Printf("Source failed: %v\n", err)
+		return false
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	ok := true
+	for _, set := range sets {
+		setReportFile := reportFile
+		if setReportFile != "" && len(sets) > 1 {
+			ext := filepath.Ext(setReportFile)
+			setReportFile = strings.TrimSuffix(setReportFile, ext) + "--" + set.Label + ext
+		}
+		if !validateSheetSet(srcDir, setReportFile, sheetOutDir(outDir, set), set, cfg, source) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func validateSheetSet(srcDir, reportFile, outDir string, set service.SheetSet, cfg *map[string]string, source service.SourceResolver) bool {
+	pkg := set.Pkg
+	fmt.Println("Parse success and get requests: ", set.Label, len(pkg.Requests))
+	// validate takes the package config, so that it passes and fails the same rows as package
+	zi := service.NewZipInstruction()
+	if err := configZipInstructure(zi, cfg); err != nil {
+		fmt.Printf("Config failed: %v\n", err)
+		return false
+	}
+	vi := service.NewValidateInstruction()
+	vi.SrcDir = srcDir
+	if source != nil {
+		vi.Source = source
+	}
+	vi.SourceLookup = zi.SourceLookup
+	vi.PreserveSubpath = zi.PreserveSubpath
+	vi.DuplicatePolicy = zi.DuplicatePolicy
+	issues, err := vi.Validate(pkg)
+	if err != nil {
+		fmt.Printf("Validate failed: %v\n", err)
+		return false
+	}
+	blocking := 0
+	for _, issue := range *issues {
+		if issue.Blocking {
+			blocking++
+		}
+		fmt.Printf("%v %v: %v\n", issue.Label(), issue.Kind, issue.Message)
+	}
+	fmt.Printf("Validate found %v issues, %v of them blocking\n", len(*issues), blocking)
+	if reportFile == "" {
+		reportFile = outDir + "/validate-result--" + set.Label + ".xlsx"
+	}
+	err = os.MkdirAll(filepath.Dir(reportFile), 0755)
+	if err != nil {
+		fmt.Printf("Output validate report failed: %v\n", err)
+		return false
+	}
+	fmt.Println("Output to: ", reportFile)
+	if strings.HasSuffix(strings.ToLower(reportFile), ".csv") {
+		f, err2 := os.Create(reportFile)
+		if err2 != nil {
+			fmt.Printf("Output validate report failed: %v\n", err2)
+			return false
+		}
+		err = vi.OutputCsv(issues, f)
+		_ = f.Close()
+	} else {
+		outXls := excelize.NewFile()
+		err = vi.OutputExcel(issues, outXls)
+		if err == nil {
+			err = outXls.SaveAs(reportFile)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Output validate report failed: %v\n", err)
+		return false
+	}
+	return !service.HasBlockingIssue(issues)
 }
 
 func reconcile(reportDir, fileEndsWith, outDir, xls, config, sheetName string) {
 	fmt.Printf("reconcile: %s %s %s %s %s %s\n", reportDir, outDir, fileEndsWith, xls, config, sheetName)
 	cfg := loadConfig(config)
 	pi := service.NewParseInstruction()
-	pi.SheetName = sheetName
-	configParseInstructure(pi, cfg)
-	pkg, err := pi.ParsePackageRequests(xls)
-	if err != nil || pkg == nil {
+	if err := configParseInstructure(pi, cfg); err != nil {
+		fmt.Printf("Config failed: %v\n", err)
+		return
+	}
+	sets, err := pi.ParseSheetSets(xls, sheetName)
+	if err != nil {
 		fmt.Printf("ParsePackageExcel failed or no requests at all: %v\n", err)
 		return
 	}
-	fmt.Println("Parse success and get requests: ", len(pkg.Requests))
-	ri := service.NewReconcileInstruction()
-	ri.ReportDir = reportDir
-	ri.OutDir = outDir
-	ri.ReportFileEndsWith = fileEndsWith
-	reconcileResults, _ := ri.Reconcile(pkg)
-	fmt.Println("Reconcile success and get results: ", len(*reconcileResults))
-	colHeaders := pi.ExtractRequestHeaders(xls)
-	outXls := excelize.NewFile()
-	ri.OutputExcel(reconcileResults, colHeaders, outXls)
-	targetFile := outDir + "/reconcile-result--" + sheetName + ".xlsx"
-	fmt.Println("Output to: ", targetFile)
-	outXls.SaveAs(targetFile)
+	for _, set := range sets {
+		fmt.Println("Parse success and get requests: ", set.Label, len(set.Pkg.Requests))
+		ri := service.NewReconcileInstruction()
+		ri.ReportDir = reportDir
+		ri.OutDir = sheetOutDir(outDir, set)
+		ri.ReportFileEndsWith = fileEndsWith
+		reconcileResults, _ := ri.Reconcile(set.Pkg)
+		fmt.Println("Reconcile success and get results: ", len(*reconcileResults))
+		outXls := excelize.NewFile()
+		ri.OutputExcel(reconcileResults, set.Headers, outXls)
+		_ = os.MkdirAll(ri.OutDir, 0755)
+		targetFile := ri.OutDir + "/reconcile-result--" + set.Label + ".xlsx"
+		fmt.Println("Output to: ", targetFile)
+		outXls.SaveAs(targetFile)
+	}
 }
 
 func usage() {
@@ -67,12 +67,52 @@ func (md *Metadata) locateOrCreateTagGroup(groupId string, groupName string) *Ta
 	return tagGroup
 }
 
+// Merge adds the tags and group tags of other, which are not there yet with the same name and value
+func (md *Metadata) Merge(other *Metadata) {
+	if other == nil {
+		return
+	}
+	for _, tag := range other.Tags {
+		if !hasTag(md.Tags, tag) {
+			md.addTag(tag.Name, tag.Value)
+		}
+	}
+	for _, tagGroup := range other.TagGroups {
+		for _, tag := range tagGroup.Tags {
+			if !hasTag(md.locateOrCreateTagGroup(tagGroup.groupId, tagGroup.GroupName).Tags, tag) {
+				md.addGroupTag(tagGroup.groupId, tagGroup.GroupName, tag.Name, tag.Value)
+			}
+		}
+	}
+}
+
+func hasTag(tags []Tag, tag Tag) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 type Request struct {
 	RowNumber int    `xml:"-"`
 	ID        string `xml:",attr"`
 	FileName  string `xml:",attr"`
 	MimeType  string `xml:",attr"`
 	DocName   string `xml:",attr,omitempty"`
+	// the sheet of the row, when requests come from a workbook
+	SheetName string `xml:"-"`
+	// path of the source file relative to the source dir, FileName is then the name of the zip entry
+	SourcePath string `xml:"-"`
+	// the FileName in the spreadsheet, when the entry was renamed for a duplicate FileName
+	OriginalFileName string `xml:",attr,omitempty"`
+	// IDs of the rows merged into this one, when they have the same FileName
+	MergedIDs string `xml:",attr,omitempty"`
+	// size and checksum of the file, filled in while zipping it
+	FileSize          int64  `xml:",attr,omitempty"`
+	ChecksumAlgorithm string `xml:",attr,omitempty"`
+	Checksum          string `xml:",attr,omitempty"`
 	//must be pointer since golang is mainly value-based.
 	//If not pointer, it creates default Metadata struct with default values for all of its fields
 	Metadata *Metadata `xml:",omitempty"`
@@ -112,7 +152,7 @@ type PkgHeader struct {
 }
 
 type PkgTrailer struct {
-	RequestCount int16
+	RequestCount int
 }
 
 type Pkg struct {
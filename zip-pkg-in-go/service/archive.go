@@ -0,0 +1,308 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// the archive format of the splits. A tarball is compressed as a whole, so the compression overrides
+// of single entries only apply to zip
+const (
+	ArchiveZip    = "zip"
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+)
+
+// archiveWriter writes the entries of a split into one archive
+type archiveWriter interface {
+	// createEntry starts an entry of size bytes, which must be closed before the next entry
+	createEntry(name string, mimeType string, size int64) (io.WriteCloser, error)
+	Close() error
+}
+
+func checkArchiveFormat(format string) error {
+	if format == ArchiveZip || format == ArchiveTarGz || format == ArchiveTarZst {
+		return nil
+	}
+	return errors.New("unknown archive format [" + format + "]")
+}
+
+// archiveExt is the file extension of a split, with the leading dot
+func (zi *ZipInstruction) archiveExt() string {
+	return "." + zi.ArchiveFormat
+}
+
+func (zi *ZipInstruction) isTar() bool {
+	return zi.ArchiveFormat == ArchiveTarGz || zi.ArchiveFormat == ArchiveTarZst
+}
+
+func (zi *ZipInstruction) newArchiveWriter(w io.Writer) (archiveWriter, error) {
+	switch zi.ArchiveFormat {
+	case ArchiveTarGz:
+		gw, err := gzip.NewWriterLevel(w, zi.Compression.Level)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(gw), comp: gw}, nil
+	case ArchiveTarZst:
+		// splits are already written in parallel, so one goroutine per encoder is enough
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zi.Compression.Level)),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), comp: zw}, nil
+	}
+	zw := zip.NewWriter(w)
+	zi.Compression.registerCompressor(zw)
+	return &zipArchiveWriter{zi: zi, zw: zw}, nil
+}
+
+type zipArchiveWriter struct {
+	zi *ZipInstruction
+	zw *zip.Writer
+}
+
+// createEntry starts a zip entry, encrypted if asked for. The size is not needed up front
+func (w *zipArchiveWriter) createEntry(name string, mimeType string, _ int64) (io.WriteCloser, error) {
+	zi := w.zi
+	method := zi.Compression.methodOf(name, mimeType)
+	if zi.Encryption == EncryptionAES256 {
+		return createAesEntry(w.zw, name, method, &zi.Compression, zi.EncryptionPassword)
+	}
+	fw, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: method,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{fw}, nil
+}
+
+func (w *zipArchiveWriter) Close() error {
+	return w.zw.Close()
+}
+
+func rawFileHeader(name string, method uint16) *zip.FileHeader {
+	fh := &zip.FileHeader{
+		Name:   name,
+		Method: method,
+		Flags:  zipFlagDescriptor,
+	}
+	if !isASCII(name) {
+		fh.Flags |= zipFlagUTF8
+	}
+	return fh
+}
+
+// zipRawEntryWriter compresses a plain entry itself, the same way archive/zip does, so that the entry can go
+// to a spool first, and its compressed size is known on close
+type zipRawEntryWriter struct {
+	fh         *zip.FileHeader
+	comp       io.WriteCloser
+	compressed *countingWriter
+	crc        hash.Hash32
+	rawCount   int64
+}
+
+func newZipRawEntryWriter(fh *zip.FileHeader, raw io.Writer, cp *CompressionPolicy) *zipRawEntryWriter {
+	w := &zipRawEntryWriter{
+		fh:         fh,
+		compressed: &countingWriter{w: raw},
+		crc:        crc32.NewIEEE(),
+	}
+	w.comp = nopWriteCloser{w.compressed}
+	if fh.Method == zip.Deflate {
+		w.comp = cp.newDeflater(w.compressed)
+	}
+	return w
+}
+
+func (w *zipRawEntryWriter) Write(p []byte) (int, error) {
+	w.rawCount += int64(len(p))
+	w.crc.Write(p)
+	return w.comp.Write(p)
+}
+
+func (w *zipRawEntryWriter) Close() error {
+	if err := w.comp.Close(); err != nil {
+		return err
+	}
+	setEntrySizes(w.fh, w.crc.Sum32(), w.compressed.n, w.rawCount)
+	return nil
+}
+
+type tarArchiveWriter struct {
+	tw   *tar.Writer
+	comp io.WriteCloser
+}
+
+func (w *tarArchiveWriter) createEntry(name string, _ string, size int64) (io.WriteCloser, error) {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  time.Now().Truncate(time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tarEntryWriter{tw: w.tw, name: name, size: size}, nil
+}
+
+// closeMember ends the compressed stream after the entries so far, without the end of the tar, so that the
+// entries of another tar writer can follow in a stream of their own. Readers go on through concatenated streams
+func (w *tarArchiveWriter) closeMember() error {
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+	return w.comp.Close()
+}
+
+func (w *tarArchiveWriter) Close() error {
+	err := w.tw.Close()
+	if closeE := w.comp.Close(); err == nil {
+		err = closeE
+	}
+	return err
+}
+
+// tarEntryWriter fails on close when the entry got less than its size, e.g. the source file shrank after stat
+type tarEntryWriter struct {
+	tw      *tar.Writer
+	name    string
+	size    int64
+	written int64
+}
+
+func (w *tarEntryWriter) Write(p []byte) (int, error) {
+	n, err := w.tw.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *tarEntryWriter) Close() error {
+	if w.written != w.size {
+		return fmt.Errorf("[%v] has %v bytes, but %v bytes were expected", w.name, w.written, w.size)
+	}
+	return nil
+}
+
+// readArchiveEntries lists the entries of a written split with their sizes and CRC,
+// a tarball is read through, since it has no directory of its entries
+func (zi *ZipInstruction) readArchiveEntries(archiveFile string) ([]SidecarEntry, error) {
+	if !zi.isTar() {
+		r, err := zip.OpenReader(archiveFile)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		entries := make([]SidecarEntry, 0, len(r.File))
+		for _, f := range r.File {
+			entries = append(entries, SidecarEntry{
+				Name:           f.Name,
+				Size:           int64(f.UncompressedSize64),
+				CompressedSize: int64(f.CompressedSize64),
+				Crc32:          fmt.Sprintf("%08x", f.CRC32),
+			})
+		}
+		return entries, nil
+	}
+	entries := make([]SidecarEntry, 0)
+	err := zi.walkArchive(archiveFile, func(name string, r io.Reader) error {
+		crc := crc32.NewIEEE()
+		n, err := io.Copy(crc, r)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, SidecarEntry{
+			Name:  name,
+			Size:  n,
+			Crc32: fmt.Sprintf("%08x", crc.Sum32()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// walkArchive calls fn with every entry of a written split in archive order, and a reader of its plain content.
+// Reading an entry through checks its CRC, and the auth code of an encrypted one
+func (zi *ZipInstruction) walkArchive(archiveFile string, fn func(name string, r io.Reader) error) error {
+	if !zi.isTar() {
+		r, err := zip.OpenReader(archiveFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		for _, f := range r.File {
+			var rc io.ReadCloser
+			if f.Method == zipMethodAES {
+				rc, err = openAesEntry(f, zi.EncryptionPassword)
+			} else {
+				rc, err = f.Open()
+			}
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, rc)
+			_ = rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	var r io.Reader
+	if zi.ArchiveFormat == ArchiveTarZst {
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"crypto/rand"
+	"os"
+	"strconv"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipTarballs(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := make([]model.Request, 0)
+	for i, size := range []int{40000, 25000, 60000, 10, 30000} {
+		// random content does not compress, so the bound of every split is tested
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		fileName := "r-" + strconv.Itoa(i+1) + ".pdf"
+		if err := os.WriteFile(srcDir+"/"+fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, model.Request{RowNumber: i + 1, ID: strconv.Itoa(i + 1), FileName: fileName})
+	}
+	for _, format := range []string{ArchiveTarGz, ArchiveTarZst} {
+		t.Run(format, func(t *testing.T) {
+			zi := newTestZipInstruction(t, srcDir)
+			zi.Unzip = true
+			zi.MaxSize = 100 * 1024
+			zi.ArchiveFormat = format
+			zi.Sidecar = true
+			summary, err := zi.Zip(&requests)
+			if err != nil {
+				t.Fatalf("Zip failed: %v", err)
+			}
+			if summary.SplitCount < 2 {
+				t.Fatalf("expect more than one split, got %v", summary.SplitCount)
+			}
+			entryCount := 0
+			for seq := 1; seq <= summary.SplitCount; seq++ {
+				fn := zi.DstDir + "/package-" + strconv.Itoa(seq)
+				info, err := os.Stat(fn + "." + format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > zi.MaxSize {
+					t.Errorf("split %v has %v bytes, exceeds max size %v", seq, info.Size(), zi.MaxSize)
+				}
+				entries, err := zi.readArchiveEntries(fn + "." + format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if last := entries[len(entries)-1]; last.Name != zi.MetaXmlFileName {
+					t.Errorf("expect meta xml last, got %v", last.Name)
+				}
+				for _, e := range entries[:len(entries)-1] {
+					src := readFile(t, srcDir+"/"+e.Name)
+					if int64(len(src)) != e.Size || string(readFile(t, fn+".d/"+e.Name)) != string(src) {
+						t.Errorf("%v differs from source", e.Name)
+					}
+				}
+				entryCount += len(entries) - 1
+				if _, err = os.Stat(fn + "." + format + ".sha256"); err != nil {
+					t.Errorf("missing sha256 sidecar: %v", err)
+				}
+			}
+			if entryCount != len(requests) {
+				t.Errorf("expect %v entries, got %v", len(requests), entryCount)
+			}
+		})
+	}
+}
+
+func TestArchiveFormatOptions(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10})
+	zi := newTestZipInstruction(t, srcDir)
+	zi.ArchiveFormat = "rar"
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Errorf("expect error for unknown archive format")
+	}
+	zi.ArchiveFormat = ArchiveTarGz
+	zi.Encryption = EncryptionAES256
+	zi.EncryptionPassword = "s3cret"
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Errorf("expect error for encrypted tarball")
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"strings"
+)
+
+const (
+	ChecksumNone   = "none"
+	ChecksumSHA256 = "SHA-256"
+	ChecksumSHA1   = "SHA-1"
+	ChecksumMD5    = "MD5"
+)
+
+// normalizeChecksumAlgorithm accepts the algorithm names with any case and with or without the dash
+func normalizeChecksumAlgorithm(algorithm string) (string, error) {
+	switch strings.ReplaceAll(strings.ToUpper(algorithm), "-", "") {
+	case "", "NONE":
+		return ChecksumNone, nil
+	case "SHA256":
+		return ChecksumSHA256, nil
+	case "SHA1":
+		return ChecksumSHA1, nil
+	case "MD5":
+		return ChecksumMD5, nil
+	}
+	return "", errors.New("unknown checksum algorithm [" + algorithm + "]")
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New()
+	case ChecksumSHA1:
+		return sha1.New()
+	case ChecksumMD5:
+		return md5.New()
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CompressionPolicy tells how each zip entry is compressed. Overrides are keyed by lower case
+// MimeType, or by file extension with the leading dot, and MimeType wins over extension
+type CompressionPolicy struct {
+	Method    uint16
+	Level     int
+	Overrides map[string]uint16
+}
+
+const metaXmlMimeType = "application/xml"
+
+func NewCompressionPolicy() CompressionPolicy {
+	return CompressionPolicy{
+		Method:    zip.Deflate,
+		Level:     zipDeflateLevel,
+		Overrides: make(map[string]uint16),
+	}
+}
+
+func ParseCompressionMethod(name string) (uint16, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "store":
+		return zip.Store, nil
+	case "deflate":
+		return zip.Deflate, nil
+	}
+	return 0, errors.New("unknown compression method [" + name + "]")
+}
+
+// ParseCompressionOverrides parses a comma separated list like "application/pdf:store,.xml:deflate"
+func ParseCompressionOverrides(overrides string) (map[string]uint16, error) {
+	ret := make(map[string]uint16)
+	for _, override := range strings.Split(overrides, ",") {
+		if strings.TrimSpace(override) == "" {
+			continue
+		}
+		idx := strings.LastIndex(override, ":")
+		if idx == -1 {
+			return nil, errors.New("compression override [" + override + "] is not in form of type:method")
+		}
+		method, err := ParseCompressionMethod(override[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		ret[strings.ToLower(strings.TrimSpace(override[:idx]))] = method
+	}
+	return ret, nil
+}
+
+func (cp *CompressionPolicy) check() error {
+	if cp.Method != zip.Store && cp.Method != zip.Deflate {
+		return errors.New("compression method must be store or deflate")
+	}
+	_, err := flate.NewWriter(io.Discard, cp.Level)
+	return err
+}
+
+func (cp *CompressionPolicy) methodOf(fileName string, mimeType string) uint16 {
+	if method, ok := cp.Overrides[strings.ToLower(mimeType)]; ok && mimeType != "" {
+		return method
+	}
+	if method, ok := cp.Overrides[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return method
+	}
+	return cp.Method
+}
+
+// registerCompressor reuses flate writers the same way as the default compressor of archive/zip,
+// since a new flate writer allocates about 1MB, which is too much for thousands of small entries
+func (cp *CompressionPolicy) registerCompressor(zw *zip.Writer) {
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return cp.newDeflater(w), nil
+	})
+}
+
+// newDeflater takes a flate writer of the policy level from the pool, check() makes sure the level is valid
+func (cp *CompressionPolicy) newDeflater(w io.Writer) io.WriteCloser {
+	pool, _ := flateWriterPools.LoadOrStore(cp.Level, &sync.Pool{})
+	fw, ok := pool.(*sync.Pool).Get().(*flate.Writer)
+	if ok {
+		fw.Reset(w)
+	} else {
+		fw, _ = flate.NewWriter(w, cp.Level)
+	}
+	return &pooledFlateWriter{fw: fw, pool: pool.(*sync.Pool)}
+}
+
+var flateWriterPools sync.Map // by level
+
+type pooledFlateWriter struct {
+	fw   *flate.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledFlateWriter) Write(p []byte) (int, error) {
+	if w.fw == nil {
+		return 0, errors.New("write to closed flate writer")
+	}
+	return w.fw.Write(p)
+}
+
+func (w *pooledFlateWriter) Close() error {
+	if w.fw == nil {
+		return nil
+	}
+	err := w.fw.Close()
+	w.pool.Put(w.fw)
+	w.fw = nil
+	return err
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"archive/zip"
+	"testing"
+)
+
+func TestCompressionPolicyMethodOf(t *testing.T) {
+	cp := NewCompressionPolicy()
+	overrides, err := ParseCompressionOverrides("application/pdf:store, .PNG:store,.xml:deflate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Overrides = overrides
+	cp.Method = zip.Store
+	tests := []struct {
+		fileName string
+		mimeType string
+		want     uint16
+	}{
+		{"a.pdf", "application/pdf", zip.Store},
+		{"a.pdf", "APPLICATION/PDF", zip.Store},
+		{"a.png", "", zip.Store},
+		{"a.xml", "application/pdf", zip.Store},
+		{"a.xml", "", zip.Deflate},
+		{"a.txt", "text/plain", zip.Store},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			if got := cp.methodOf(tt.fileName, tt.mimeType); got != tt.want {
+				t.Errorf("methodOf(%v, %v) = %v, want %v", tt.fileName, tt.mimeType, got, tt.want)
+			}
+		})
+	}
+	if _, err = ParseCompressionOverrides("application/pdf"); err == nil {
+		t.Errorf("expect error for override without method")
+	}
+	if _, err = ParseCompressionOverrides("application/pdf:zstd"); err == nil {
+		t.Errorf("expect error for unknown method")
+	}
+}
+
+func TestZipWithCompressionPolicy(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 30000})
+	requests[1].MimeType = "image/png"
+	zi := newTestZipInstruction(t, srcDir)
+	zi.Compression.Level = 9
+	zi.Compression.Overrides = map[string]uint16{"image/png": zip.Store}
+	if _, err := zi.Zip(&requests); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	r, err := zip.OpenReader(zi.DstDir + "/package-1.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	want := []uint16{zip.Deflate, zip.Store, zip.Deflate}
+	for i, f := range r.File {
+		if f.Method != want[i] {
+			t.Errorf("%v got method %v, want %v", f.Name, f.Method, want[i])
+		}
+	}
+	if r.File[1].CompressedSize64 != 30000 {
+		t.Errorf("expect stored entry unchanged, got %v bytes", r.File[1].CompressedSize64)
+	}
+}
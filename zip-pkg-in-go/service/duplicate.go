@@ -0,0 +1,117 @@
+package service
+
+import (
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// what to do with rows of the same FileName, i.e. the same zip entry name. They are found across the whole run before
+// the splits are planned, so that the zip, the unzip dir and the metadata xml of every split agree.
+// Dedupe keeps the first row with the tags of all of them, and rename suffixes the later ones as name-2.ext, name-3.ext
+const (
+	DuplicateError  = "error"
+	DuplicateDedupe = "dedupe"
+	DuplicateRename = "rename"
+)
+
+type DuplicateFileNameError struct {
+	Issues []ValidationIssue
+}
+
+func (e *DuplicateFileNameError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		msgs = append(msgs, issue.Label()+" "+issue.Message)
+	}
+	return strconv.Itoa(len(e.Issues)) + " duplicate FileName(s): " + strings.Join(msgs, "; ")
+}
+
+func checkDuplicatePolicy(policy string) error {
+	if policy == DuplicateError || policy == DuplicateDedupe || policy == DuplicateRename {
+		return nil
+	}
+	return errors.New("unknown duplicate policy [" + policy + "]")
+}
+
+// applyDuplicatePolicy returns the requests without duplicate FileNames, it runs after the sources are resolved
+func (zi *ZipInstruction) applyDuplicatePolicy(requests []model.Request) ([]model.Request, error) {
+	ret, issues := resolveDuplicates(zi.DuplicatePolicy, requests)
+	blocking := make([]ValidationIssue, 0)
+	for _, issue := range issues {
+		if issue.Blocking {
+			blocking = append(blocking, issue)
+		}
+	}
+	if len(blocking) > 0 {
+		return nil, &DuplicateFileNameError{Issues: blocking}
+	}
+	return ret, nil
+}
+
+// resolveDuplicates applies a duplicate policy, and returns the rows it cannot take as blocking issues, and the rows
+// it dedupes or renames as non-blocking ones. Validate uses it as well, so that it blocks on the same rows as package
+func resolveDuplicates(policy string, requests []model.Request) ([]model.Request, []ValidationIssue) {
+	firstByName := make(map[string]int) // index in ret
+	ret := make([]model.Request, 0, len(requests))
+	issues := make([]ValidationIssue, 0)
+	for _, req := range requests {
+		idx, ok := firstByName[req.FileName]
+		if !ok {
+			firstByName[req.FileName] = len(ret)
+			ret = append(ret, req)
+			continue
+		}
+		first := &ret[idx]
+		switch policy {
+		case DuplicateDedupe:
+			if sourcePath(&req) != sourcePath(first) {
+				issues = append(issues, rowIssue(req, IssueDuplicateFileName, true, "is a different source file than "+
+					rowLabel(first.SheetName, first.RowNumber)+", so it cannot be deduped"))
+				continue
+			}
+			if req.Metadata != nil {
+				if first.Metadata == nil {
+					first.Metadata = &model.Metadata{}
+				}
+				first.Metadata.Merge(req.Metadata)
+			}
+			if req.ID != first.ID {
+				first.MergedIDs = strings.TrimPrefix(first.MergedIDs+","+req.ID, ",")
+			}
+			issues = append(issues, rowIssue(req, IssueDuplicateFileName, false, "is used by "+
+				rowLabel(first.SheetName, first.RowNumber)+" already, duplicate policy "+policy+" merges it into that row"))
+		case DuplicateRename:
+			ext := path.Ext(req.FileName)
+			stem := strings.TrimSuffix(req.FileName, ext)
+			for n := 2; ; n++ {
+				renamed := stem + "-" + strconv.Itoa(n) + ext
+				if _, taken := firstByName[renamed]; !taken && !hasFileName(requests, renamed) {
+					issues = append(issues, rowIssue(req, IssueDuplicateFileName, false, "is used by "+
+						rowLabel(first.SheetName, first.RowNumber)+" already, duplicate policy "+policy+" renames it to "+renamed))
+					req.OriginalFileName = req.FileName
+					req.FileName = renamed
+					break
+				}
+			}
+			firstByName[req.FileName] = len(ret)
+			ret = append(ret, req)
+		default:
+			issues = append(issues, rowIssue(req, IssueDuplicateFileName, true,
+				"is used by "+rowLabel(first.SheetName, first.RowNumber)+" already"))
+		}
+	}
+	return ret, issues
+}
+
+// hasFileName tells whether a later row has the name of its own, so that a renamed entry does not take it
+func hasFileName(requests []model.Request, fileName string) bool {
+	for i := range requests {
+		if requests[i].FileName == fileName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipDuplicatePolicies(t *testing.T) {
+	srcDir := t.TempDir()
+	newRequests := func() []model.Request {
+		requests := makeSourceFiles(t, srcDir, []int{100, 200})
+		first := &model.Metadata{}
+		first.AddTagOrGroupTag("", "", "Type", "Passport")
+		second := &model.Metadata{}
+		second.AddTagOrGroupTag("", "", "Type", "Passport")
+		second.AddTagOrGroupTag("", "", "Country", "CA")
+		second.AddTagOrGroupTag("g1", "Owner", "Name", "David")
+		requests[0].Metadata = first
+		return append(requests,
+			model.Request{RowNumber: 3, ID: "3", FileName: "f-1.pdf", Metadata: second},
+			model.Request{RowNumber: 4, ID: "4", FileName: "f-1.pdf"})
+	}
+
+	requests := newRequests()
+	zi := newDuplicateTestInstruction(t, srcDir, DuplicateError)
+	_, err := zi.Zip(&requests)
+	var dupErr *DuplicateFileNameError
+	if !errors.As(err, &dupErr) || len(dupErr.Issues) != 2 || dupErr.Issues[0].RowNumber != 3 || dupErr.Issues[1].RowNumber != 4 {
+		t.Fatalf("expect rows 3 and 4 as duplicates, got %v", err)
+	}
+
+	requests = newRequests()
+	zi = newDuplicateTestInstruction(t, srcDir, DuplicateDedupe)
+	if _, err = zi.Zip(&requests); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	pkg := readMetaXml(t, zi.DstDir+"/package-1.zip", zi.MetaXmlFileName)
+	if len(pkg.Requests) != 2 || pkg.Requests[0].MergedIDs != "3,4" {
+		t.Fatalf("expect f-1.pdf deduped with merged IDs, got %+v", pkg.Requests)
+	}
+	md := pkg.Requests[0].Metadata
+	if len(md.Tags) != 2 || md.Tags[1].Value != "CA" || len(md.TagGroups) != 1 || md.TagGroups[0].Tags[0].Value != "David" {
+		t.Errorf("unexpected merged metadata %+v", md)
+	}
+
+	requests = newRequests()
+	// f-1-2.pdf is taken by a file of its own, so the renames skip it
+	if err = os.WriteFile(srcDir+"/f-1-2.pdf", []byte("own"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	requests = append(requests, model.Request{RowNumber: 5, ID: "5", FileName: "f-1-2.pdf"})
+	zi = newDuplicateTestInstruction(t, srcDir, DuplicateRename)
+	if _, err = zi.Zip(&requests); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	want := []string{"f-1.pdf", "f-2.pdf", "f-1-3.pdf", "f-1-4.pdf", "f-1-2.pdf", zi.MetaXmlFileName}
+	if got := readZipEntries(t, zi.DstDir, []string{"package-1"})[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+	pkg = readMetaXml(t, zi.DstDir+"/package-1.zip", zi.MetaXmlFileName)
+	if r := pkg.Requests[2]; r.FileName != "f-1-3.pdf" || r.OriginalFileName != "f-1.pdf" {
+		t.Errorf("unexpected renamed request %+v", r)
+	}
+	if string(readFile(t, zi.DstDir+"/package-1.d/f-1-4.pdf")) != string(readFile(t, srcDir+"/f-1.pdf")) {
+		t.Errorf("expect renamed copy of f-1.pdf in unzip dir")
+	}
+}
+
+func newDuplicateTestInstruction(t *testing.T, srcDir string, policy string) *ZipInstruction {
+	zi := newTestZipInstruction(t, srcDir)
+	zi.Unzip = true
+	zi.DuplicatePolicy = policy
+	zi.Verify = true
+	return zi
+}
+
+func TestDuplicateAcrossSheetsNamesTheSheets(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{100})
+	requests[0].SheetName = "Sheet1"
+	requests = append(requests, model.Request{SheetName: "Sheet2", RowNumber: 1, ID: "2", FileName: "f-1.pdf"})
+	zi := newDuplicateTestInstruction(t, srcDir, DuplicateError)
+	_, err := zi.Zip(&requests)
+	want := "1 duplicate FileName(s): sheet [Sheet2] row 1 [f-1.pdf] is used by sheet [Sheet1] row 1 already"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
@@ -2,7 +2,7 @@ package service
 
 import (
 	"fmt"
-	"github.com/xuri/excelize/v2"
+	"io"
 	"strconv"
 	"strings"
 	"zip-pkg-in-go/model"
@@ -17,6 +17,14 @@ type ParseInstruction struct {
 	defaultMimeType         string
 	defaultDocName          string
 	SheetName               string
+	CsvDelimiter            string // empty for comma in .csv and tab in .tsv
+	CsvQuoting              string
+	CsvEncoding             string
+	SheetOutput             string
+	HeaderRowOffset         int  // rows above the header row, like a title banner
+	HeaderRowAuto           bool // the header row is the first one with a FileName cell, instead of at the offset
+	TwoRowHeader            bool // group names in the header row, and tag names in the row below
+	idOffset                int  // of generated IDs, when the sheet follows others in a combined set
 }
 
 type ColHeader struct {
@@ -80,6 +88,9 @@ func NewParseInstruction() *ParseInstruction {
 		groupSuffix:             "]",
 		groupIdNameDelimiter:    ":",
 		SheetName:               "Sheet1",
+		CsvQuoting:              CsvQuoteStandard,
+		CsvEncoding:             "utf-8",
+		SheetOutput:             SheetOutputCombined,
 	}
 }
 
@@ -100,75 +111,105 @@ func (pi *ParseInstruction) SetGroupIdNameDelimiter(delim string) {
 	pi.groupIdNameDelimiter = delim
 }
 
+// ExtractRequestHeaders reads the header row only, use ParsePackageRequestsAndHeaders for both requests and headers
 func (pi *ParseInstruction) ExtractRequestHeaders(xlsx string) *[]ColHeader {
+	if isRequestManifest(xlsx) {
+		_, headers, err := pi.parseRequestManifest(xlsx)
+		if err != nil {
+			return &[]ColHeader{}
+		}
+		return headers
+	}
 	// because parse Request happens before, it has no issue when reaching here
-	f, _ := excelize.OpenFile(xlsx)
+	src, _ := pi.OpenRequestSource(xlsx)
 	defer func() {
-		_ = f.Close()
+		_ = src.Close()
 	}()
-	rows, _ := f.GetRows(pi.SheetName)
+	row, _, _ := pi.readHeaderRow(src)
 	var headers []ColHeader = make([]ColHeader, 0)
-	_ = pi.parseHeaderRow(rows[0], func(validHeader *ColHeader, colNum int) {
+	_ = pi.parseHeaderRow(row, func(validHeader *ColHeader, colNum int) {
 		headers = append(headers, *validHeader)
 	})
 	return &headers
 }
 
+// ParsePackageRequests reads an excel workbook, a csv or tsv file, see OpenRequestSource,
+// or a JSON or YAML request manifest
 func (pi *ParseInstruction) ParsePackageRequests(xlsx string) (*model.Pkg, error) {
-	f, err := excelize.OpenFile(xlsx)
+	pkg, _, err := pi.ParsePackageRequestsAndHeaders(xlsx)
+	return pkg, err
+}
+
+// ParsePackageRequestsAndHeaders reads the rows one by one in a single pass, so that a large sheet takes bounded memory
+func (pi *ParseInstruction) ParsePackageRequestsAndHeaders(xlsx string) (*model.Pkg, *[]ColHeader, error) {
+	if isRequestManifest(xlsx) {
+		return pi.parseRequestManifest(xlsx)
+	}
+	src, err := pi.OpenRequestSource(xlsx)
 	if err != nil {
-		fmt.Printf("Error openinng excel file: %v\n", err)
-		return nil, err
+		fmt.Printf("Error openinng request file: %v\n", err)
+		return nil, nil, err
 	}
 	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Printf("Error closing excel file: %v\n", err)
+		if err := src.Close(); err != nil {
+			fmt.Printf("Error closing request file: %v\n", err)
 		}
 	}()
-	rows, err := f.GetRows(pi.SheetName)
-	if err != nil {
-		fmt.Printf("Error get excel rows: %v\n", err)
-		return nil, err
+	sheetName := ""
+	if _, ok := src.(*excelRequestSource); ok {
+		sheetName = pi.SheetName
 	}
+	headers := make([]ColHeader, 0)
 	ret := &model.Pkg{}
 	ret.Requests = make([]model.Request, 0)
 
 	var headerMap map[int]*ColHeader = make(map[int]*ColHeader)
 	var continueEmptyRowCount int8 = 0
-	var maxColIdx = 0
 	var seq = 0
-	for i, row := range rows {
-		if i == 0 { // header row
-			maxColIdx = pi.parseHeaderRow(row, func(validHeader *ColHeader, colNum int) {
-				headerMap[colNum] = validHeader
-			})
+	headerRow, headerIdx, err := pi.readHeaderRow(src)
+	if err != nil {
+		fmt.Printf("Error get header row: %v\n", err)
+		return nil, nil, err
+	}
+	maxColIdx := pi.parseHeaderRow(headerRow, func(validHeader *ColHeader, colNum int) {
+		headerMap[colNum] = validHeader
+		headers = append(headers, *validHeader)
+	})
+	for i := headerIdx + 1; ; i++ {
+		row, err := src.ReadRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Error get rows: %v\n", err)
+			return nil, nil, err
+		}
+		req, status := pi.buildRequestAndStatus(row, &headerMap, maxColIdx)
+		if status == 2 { // empty row
+			continueEmptyRowCount += 1
+			if continueEmptyRowCount > pi.continuousEmptyRowLimit {
+				break
+			}
+		} else if status == 1 { // ignore row
+			continueEmptyRowCount = 0
 		} else {
-			req, status := pi.buildRequestAndStatus(row, &headerMap, maxColIdx)
-			if status == 2 { // empty row
-				continueEmptyRowCount += 1
-				if continueEmptyRowCount > pi.continuousEmptyRowLimit {
-					break
-				}
-			} else if status == 1 { // ignore row
-				continueEmptyRowCount = 0
-			} else {
-				continueEmptyRowCount = 0
-				req.RowNumber = i
-				seq += 1
-				if req.ID == "" {
-					req.ID = strconv.Itoa(seq)
-				}
-				if req.DocName == "" && pi.defaultDocName != "" {
-					req.DocName = pi.defaultDocName
-				}
-				if req.MimeType == "" && pi.defaultMimeType != "" {
-					req.MimeType = pi.defaultMimeType
-				}
-				ret.Requests = append(ret.Requests, *req)
+			continueEmptyRowCount = 0
+			req.RowNumber = i
+			seq += 1
+			req.SheetName = sheetName
+			if req.ID == "" {
+				req.ID = strconv.Itoa(pi.idOffset + seq)
+			}
+			if req.DocName == "" && pi.defaultDocName != "" {
+				req.DocName = pi.defaultDocName
+			}
+			if req.MimeType == "" && pi.defaultMimeType != "" {
+				req.MimeType = pi.defaultMimeType
 			}
+			ret.Requests = append(ret.Requests, *req)
 		}
 	}
-	return ret, nil
+	return ret, &headers, nil
 }
 
 func (pi *ParseInstruction) buildRequestAndStatus(row []string, headerMap *map[int]*ColHeader, maxColIdx int) (*model.Request, int8) {
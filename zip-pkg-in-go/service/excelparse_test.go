@@ -3,7 +3,10 @@ package service
 import (
 	"encoding/xml"
 	"fmt"
+	"github.com/xuri/excelize/v2"
 	"os"
+	"reflect"
+	"strconv"
 	"testing"
 	"zip-pkg-in-go/model"
 )
@@ -65,11 +68,63 @@ func TestParsePackageExcelSheet2(t *testing.T) {
 	})
 }
 
+func TestParsePackageRequestsAndHeaders(t *testing.T) {
+	pi := NewParseInstruction()
+	pkg, headers, err := pi.ParsePackageRequestsAndHeaders("../testdata/excel/pkg-test.xlsx")
+	if err != nil || len(pkg.Requests) != 2 {
+		t.Fatalf("ParsePackageRequestsAndHeaders failed: %v", err)
+	}
+	if want := pi.ExtractRequestHeaders("../testdata/excel/pkg-test.xlsx"); !reflect.DeepEqual(headers, want) {
+		t.Errorf("got headers %+v, want %+v", headers, want)
+	}
+}
+
+func TestParsePackageRequestsOfLargeSheet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip writing and parsing 100000 rows in short mode")
+	}
+	xlsx := t.TempDir() + "/large.xlsx"
+	f := excelize.NewFile()
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sw.SetRow("A1", []interface{}{"FileName", "MimeType", "Name", "[Contact] Email"})
+	for i := 1; i <= 100000; i++ {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		n := strconv.Itoa(i)
+		_ = sw.SetRow(cell, []interface{}{"f-" + n + ".pdf", "application/pdf", "name-" + n, n + "@example.com"})
+	}
+	if err = sw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.SaveAs(xlsx); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	pkg, headers, err := NewParseInstruction().ParsePackageRequestsAndHeaders(xlsx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*headers) != 4 || len(pkg.Requests) != 100000 {
+		t.Fatalf("got %v headers and %v requests", len(*headers), len(pkg.Requests))
+	}
+	last := pkg.Requests[99999]
+	if last.RowNumber != 100000 || last.FileName != "f-100000.pdf" || last.GetTagGroupValue("", "Contact", "Email") != "100000@example.com" {
+		t.Errorf("unexpected last request %+v", last)
+	}
+}
+
 func testSheetWith(t *testing.T, sheetName string, groupPrefix string, groupSuffix string, pkgRefiner func(pkg *model.Pkg)) {
 	pi := NewParseInstruction()
 	pi.SheetName = sheetName
 	pi.SetGroupNameDelimiter(groupPrefix, groupSuffix)
-	pkg, err := pi.ParsePackageRequests("../testdata/excel/pkg-test.xlsx")
+	testFileWith(t, pi, "../testdata/excel/pkg-test.xlsx", pkgRefiner)
+}
+
+func testFileWith(t *testing.T, pi *ParseInstruction, file string, pkgRefiner func(pkg *model.Pkg)) {
+	pkg, err := pi.ParsePackageRequests(file)
 	if err != nil || pkg == nil {
 		t.Errorf("ParsePackageExcel failed: %v", err)
 		return
@@ -81,7 +136,7 @@ func testSheetWith(t *testing.T, sheetName string, groupPrefix string, groupSuff
 		Source:         "UnitTest",
 	}
 	pkg.Trailer = model.PkgTrailer{
-		RequestCount: int16(len(pkg.Requests)),
+		RequestCount: len(pkg.Requests),
 	}
 	out, _ := xml.MarshalIndent(pkg, "", "    ")
 	got := string(out)
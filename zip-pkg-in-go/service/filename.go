@@ -0,0 +1,69 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// UnsafeFileNameError lists every row whose FileName must not be used as a source path or zip entry name
+type UnsafeFileNameError struct {
+	Issues []ValidationIssue
+}
+
+func (e *UnsafeFileNameError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		msgs = append(msgs, issue.Label()+" "+issue.Message)
+	}
+	return strconv.Itoa(len(e.Issues)) + " unsafe FileName(s): " + strings.Join(msgs, "; ")
+}
+
+var windowsReservedNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true}
+
+func init() {
+	for i := 1; i <= 9; i++ {
+		windowsReservedNames["COM"+strconv.Itoa(i)] = true
+		windowsReservedNames["LPT"+strconv.Itoa(i)] = true
+	}
+}
+
+// checkFileName tells why a FileName from the spreadsheet is unsafe, or returns empty when it is a plain relative path.
+// Backslashes count as separators, since the zip may be extracted on Windows
+func checkFileName(name string) string {
+	if name == "" {
+		return "is empty"
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return "has control character " + strconv.QuoteRune(r)
+		}
+	}
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || (len(name) >= 2 && name[1] == ':') {
+		return "is an absolute path"
+	}
+	for _, segment := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return "has a .. segment"
+		}
+		stem, _, _ := strings.Cut(segment, ".")
+		if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+			return "has reserved Windows name [" + segment + "]"
+		}
+	}
+	return ""
+}
+
+// checkFileNames fails with every unsafe FileName of the requests, each with its row number
+func checkFileNames(requests []model.Request) error {
+	issues := make([]ValidationIssue, 0)
+	for _, req := range requests {
+		if msg := checkFileName(req.FileName); msg != "" {
+			issues = append(issues, rowIssue(req, IssueUnsafeFileName, true, msg))
+		}
+	}
+	if len(issues) > 0 {
+		return &UnsafeFileNameError{Issues: issues}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestCheckFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		safe bool
+	}{
+		{"a.pdf", true},
+		{"2024/01/a.pdf", true},
+		{"a..b.pdf", true},
+		{"console.pdf", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"\\\\server\\share\\a.pdf", false},
+		{"C:\\a.pdf", false},
+		{"../../etc/passwd", false},
+		{"a/../../b.pdf", false},
+		{"a\\..\\b.pdf", false},
+		{"a\tb.pdf", false},
+		{"a\x7f.pdf", false},
+		{"CON", false},
+		{"docs/nul.txt", false},
+		{"Com1.pdf", false},
+		{"lpt9 .log", false},
+	}
+	for _, tt := range tests {
+		if got := checkFileName(tt.name) == ""; got != tt.safe {
+			t.Errorf("checkFileName(%q) safe = %v, want %v", tt.name, got, tt.safe)
+		}
+	}
+}
+
+func TestZipRejectsUnsafeFileNames(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10})
+	requests = append(requests,
+		model.Request{RowNumber: 12, ID: "12", FileName: "../../etc/passwd"},
+		model.Request{RowNumber: 15, ID: "15", FileName: "aux.pdf"})
+	zi := newTestZipInstruction(t, srcDir)
+	_, err := zi.Zip(&requests)
+	var unsafeErr *UnsafeFileNameError
+	if !errors.As(err, &unsafeErr) {
+		t.Fatalf("expect UnsafeFileNameError, got %v", err)
+	}
+	if len(unsafeErr.Issues) != 2 || unsafeErr.Issues[0].RowNumber != 12 || unsafeErr.Issues[1].RowNumber != 15 {
+		t.Errorf("unexpected issues %+v", unsafeErr.Issues)
+	}
+	if names := dirEntryNames(t, zi.DstDir); len(names) != 0 {
+		t.Errorf("expect nothing written, got %v", names)
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const headerSearchLimit = 100
+
+// readHeaderRow skips the rows above the header, and returns the header row with the index of its last row.
+// Two header rows are merged into one, see mergeHeaderRows
+func (pi *ParseInstruction) readHeaderRow(src RequestSource) ([]string, int, error) {
+	for i := 0; ; i++ {
+		row, err := src.ReadRow()
+		if err == io.EOF {
+			if i == 0 {
+				return []string{}, 0, nil
+			}
+			return nil, i, errors.New("no header row found in " + strconv.Itoa(i) + " rows")
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		if pi.HeaderRowAuto {
+			if !hasFileNameCell(row) {
+				if i+1 >= headerSearchLimit {
+					return nil, i, errors.New("no FileName column in the first " + strconv.Itoa(headerSearchLimit) + " rows")
+				}
+				continue
+			}
+		} else if i < pi.HeaderRowOffset {
+			continue
+		}
+		if !pi.TwoRowHeader {
+			return row, i, nil
+		}
+		tagRow, err := src.ReadRow()
+		if err != nil && err != io.EOF {
+			return nil, i, err
+		}
+		return pi.mergeHeaderRows(row, tagRow), i + 1, nil
+	}
+}
+
+func hasFileNameCell(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) == "FileName" {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeHeaderRows writes two header rows in the one row grammar, e.g. IssueInfo over IssueDate becomes
+// [IssueInfo] IssueDate. A group name stays for the columns on its right with an empty group cell, like
+// a merged cell, until a column with a header in the group row only, like FileName or an ungrouped tag
+func (pi *ParseInstruction) mergeHeaderRows(groupRow []string, tagRow []string) []string {
+	ret := make([]string, 0, len(tagRow))
+	group := ""
+	for j := 0; j < len(groupRow) || j < len(tagRow); j++ {
+		g, t := "", ""
+		if j < len(groupRow) {
+			g = strings.TrimSpace(groupRow[j])
+		}
+		if j < len(tagRow) {
+			t = strings.TrimSpace(tagRow[j])
+		}
+		if g != "" {
+			group = g
+		}
+		switch {
+		case t == "":
+			group = ""
+			ret = append(ret, g)
+		case group == "" || isReservedHeader(t):
+			ret = append(ret, t)
+		default:
+			ret = append(ret, pi.groupPrefix+group+pi.groupSuffix+" "+t)
+		}
+	}
+	return ret
+}
+
+func isReservedHeader(name string) bool {
+	return name == "Skip" || name == "FileName" || name == "MimeType" || name == "DocName" || name == "RefID"
+}
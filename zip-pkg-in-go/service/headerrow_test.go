@@ -0,0 +1,66 @@
+package service
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderRowPosition(t *testing.T) {
+	dir := t.TempDir()
+	banner := "Passport requests,,\nFill in one row per file,,\n,,\n"
+	tests := []struct {
+		name      string
+		content   string
+		configure func(pi *ParseInstruction)
+		wantRow   int
+		wantErr   bool
+	}{
+		{"offset", banner + "FileName,FirstName,[IssueInfo] IssueDate\na.pdf,David,2011/01/01\n",
+			func(pi *ParseInstruction) { pi.HeaderRowOffset = 3 }, 4, false},
+		{"auto", banner + "FileName,FirstName,[IssueInfo] IssueDate\na.pdf,David,2011/01/01\n",
+			func(pi *ParseInstruction) { pi.HeaderRowAuto = true }, 4, false},
+		{"two rows", "FileName,,IssueInfo\n,FirstName,IssueDate\na.pdf,David,2011/01/01\n",
+			func(pi *ParseInstruction) { pi.TwoRowHeader = true }, 2, false},
+		{"auto two rows", banner + "FileName,,IssueInfo\n,FirstName,IssueDate\na.pdf,David,2011/01/01\n",
+			func(pi *ParseInstruction) { pi.HeaderRowAuto = true; pi.TwoRowHeader = true }, 5, false},
+		{"auto without FileName", banner + "Name\nDavid\n",
+			func(pi *ParseInstruction) { pi.HeaderRowAuto = true }, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := dir + "/" + tt.name + ".csv"
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			pi := NewParseInstruction()
+			tt.configure(pi)
+			pkg, headers, err := pi.ParsePackageRequestsAndHeaders(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(pkg.Requests) != 1 || len(*headers) != 3 {
+				t.Fatalf("got %+v with headers %+v", pkg.Requests, headers)
+			}
+			req := pkg.Requests[0]
+			if req.RowNumber != tt.wantRow || req.FileName != "a.pdf" || req.GetTagValue("FirstName") != "David" ||
+				req.GetTagGroupValue("", "IssueInfo", "IssueDate") != "2011/01/01" {
+				t.Errorf("unexpected request %+v %+v", req, req.Metadata)
+			}
+		})
+	}
+}
+
+func TestMergeHeaderRows(t *testing.T) {
+	pi := NewParseInstruction()
+	groupRow := []string{"FileName", "IssueInfo", "", "", "2:IssueInfo", "Notes", "ContactInfo"}
+	tagRow := []string{"", "IssueDate", "ExpiryDate", "", "IssuePlace", "", "Phone", "Email"}
+	want := []string{"FileName", "[IssueInfo] IssueDate", "[IssueInfo] ExpiryDate", "", "[2:IssueInfo] IssuePlace",
+		"Notes", "[ContactInfo] Phone", "[ContactInfo] Email"}
+	if got := pi.mergeHeaderRows(groupRow, tagRow); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
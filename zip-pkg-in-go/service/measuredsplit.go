@@ -0,0 +1,476 @@
+package service
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"zip-pkg-in-go/model"
+)
+
+// measuredDir holds the splits of a measured run under provisional names in the staging dir,
+// since ${splitCount} is only known once the last split is written
+const measuredDir = ".measured"
+
+// measuredSplit is the split a measured run writes to. Used never falls below its size on disk once the
+// split is closed: the fixed size, the exact size of every entry written so far, and the bound of its metadata xml
+type measuredSplit struct {
+	split  zipSplit
+	file   *os.File
+	h      hash.Hash
+	out    io.Writer
+	zw     *zip.Writer // nil for a tarball
+	used   int64
+	unzipD string
+}
+
+// zipMeasured writes the splits one by one, measuring every entry while it is written, instead of planning them
+// by the bounds of the raw sizes. Splits are filled in spreadsheet order, so only the sequential strategy applies
+func (zi *ZipInstruction) zipMeasured(requests []model.Request) (*ZipSummary, error) {
+	if zi.SplitStrategy != "" && zi.SplitStrategy != SplitSequential {
+		return nil, errors.New("split strategy [" + zi.SplitStrategy + "] cannot be used with measured compressed sizes")
+	}
+	_, err := zi.splitCapacity()
+	if err != nil {
+		return nil, err
+	}
+	stagingDir, err := zi.newStagingDir()
+	if err != nil {
+		return nil, err
+	}
+	manifest := newRunManifest(stagingDir, zi.ArchiveFormat, zi.RunID, nil, nil)
+	manifest.Measured = true
+	err = manifest.save()
+	if err != nil {
+		zi.abortStagingDir(stagingDir)
+		return nil, err
+	}
+	return zi.runMeasuredSplits(manifest, nil, requests)
+}
+
+// runMeasuredSplits keeps the done splits of a failed run up to the first one which is not verified, and fills new
+// splits with the requests which are not in them. The splits get their target file names once all are written
+func (zi *ZipInstruction) runMeasuredSplits(manifest *RunManifest, done []zipSplit, requests []model.Request) (*ZipSummary, error) {
+	stagingDir := manifest.stagingDir()
+	kept := 0
+	for kept < len(done) && manifest.verifiedDone(manifest.Splits[kept], zi.archiveExt()) {
+		fmt.Println("verified: ", done[kept].fileName)
+		kept++
+	}
+	for _, split := range done[kept:] {
+		zi.removeSplitOutput(stagingDir, split)
+	}
+	splits := done[:kept]
+	manifest.Splits = manifest.Splits[:kept]
+	keptRows := make(map[sheetRow]bool)
+	for _, split := range splits {
+		for _, req := range split.requests {
+			keptRows[sheetRow{req.SheetName, req.RowNumber}] = true
+		}
+	}
+	todo := make([]model.Request, 0, len(requests))
+	for _, req := range requests {
+		if !keptRows[sheetRow{req.SheetName, req.RowNumber}] {
+			todo = append(todo, req)
+		}
+	}
+	var rejects []OversizeFile
+	err := os.MkdirAll(stagingDir+"/"+measuredDir, 0755)
+	if err == nil {
+		splits, rejects, err = zi.writeMeasuredSplits(manifest, splits, todo)
+	}
+	if err == nil {
+		err = zi.nameMeasuredSplits(manifest, splits)
+	}
+	rejectsFile := ""
+	if err == nil && len(rejects) > 0 {
+		rejectsFile = zi.uniqueRejectsFileName()
+		err = zi.writeRejectsReport(rejects, stagingDir, rejectsFile)
+	}
+	if err == nil {
+		err = commitStagingDir(stagingDir, zi.DstDir)
+	}
+	var oversizeErr *OversizeError
+	if errors.As(err, &oversizeErr) {
+		// a resume would fail the same way
+		zi.abortStagingDir(stagingDir)
+		if !zi.KeepPartial {
+			_ = manifest.remove()
+		}
+		return nil, err
+	}
+	if err != nil {
+		zi.keepDoneSplits(manifest, splits)
+		fmt.Println("Resume with run manifest: ", manifest.Path())
+		return nil, err
+	}
+	_ = manifest.remove()
+	return zi.summarize(splits, rejects, rejectsFile), nil
+}
+
+// writeMeasuredSplits adds the requests to the current split, and starts the next one when a request does not fit.
+// A request whose bound fits into the room left is written into the split directly. Any other is written into a spool
+// first for its exact size, and copied into the split where it fits, so that every file is read once.
+// Requests which do not fit into an empty split are handled by the oversize policy.
+// The splits written so far are returned on failure as well
+func (zi *ZipInstruction) writeMeasuredSplits(manifest *RunManifest, splits []zipSplit, requests []model.Request) ([]zipSplit, []OversizeFile, error) {
+	stagingDir := manifest.stagingDir()
+	maxSize := zi.effectiveMaxSize()
+	rejects := make([]OversizeFile, 0)
+	failed := make([]OversizeFile, 0) // of the fail policy
+	var ms *measuredSplit
+	var spool *entrySpool
+	fail := func(err error) ([]zipSplit, []OversizeFile, error) {
+		if spool != nil {
+			spool.remove()
+		}
+		if ms != nil {
+			_ = ms.file.Close()
+			if !zi.KeepPartial {
+				zi.removeSplitOutput(stagingDir, ms.split)
+			}
+		}
+		return splits, rejects, err
+	}
+	finish := func() error {
+		checksum, err := zi.finishMeasuredSplit(ms)
+		if err != nil {
+			return err
+		}
+		if err = manifest.addDone(ms.split, checksum); err != nil {
+			return err
+		}
+		splits = append(splits, ms.split)
+		ms = nil
+		return nil
+	}
+	for i := range requests {
+		req := &requests[i]
+		rawSize, err := zi.source().Stat(sourcePath(req))
+		if err != nil {
+			return fail(err)
+		}
+		xmlSize := zi.requestXmlSize(zi.withChecksumPlaceholder(req, rawSize))
+		if len(failed) > 0 {
+			// the run fails, the rest is only measured to list every file which does not fit
+			if zi.splitFixedSize()+zi.entryBound(req, rawSize)+xmlSize > maxSize {
+				if spool, err = zi.spoolEntry(stagingDir, req); err != nil {
+					return fail(err)
+				}
+				if size := spool.sink.size() + xmlSize; zi.splitFixedSize()+size > maxSize {
+					failed = append(failed, OversizeFile{Request: req, Size: size})
+				}
+				spool.remove()
+				spool = nil
+			}
+			continue
+		}
+		if ms != nil && zi.maxRequestsPerSplit() > 0 && len(ms.split.requests) >= zi.maxRequestsPerSplit() {
+			if err = finish(); err != nil {
+				return fail(err)
+			}
+		}
+		if ms == nil {
+			if ms, err = zi.openMeasuredSplit(stagingDir, len(splits)+1); err != nil {
+				return fail(err)
+			}
+		}
+		if ms.used+zi.entryBound(req, rawSize)+xmlSize <= maxSize {
+			size, err := zi.writeMeasuredEntry(ms, req)
+			if err == nil {
+				err = zi.addMeasuredEntry(ms, req, size+xmlSize)
+			}
+			if err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		if spool, err = zi.spoolEntry(stagingDir, req); err != nil {
+			return fail(err)
+		}
+		size := spool.sink.size() + xmlSize
+		oversize := zi.splitFixedSize()+size > maxSize
+		if oversize && zi.OversizePolicy != OversizeIsolate {
+			spool.remove()
+			spool = nil
+			if zi.OversizePolicy == OversizeFail {
+				failed = append(failed, OversizeFile{Request: req, Size: size})
+			} else {
+				rejects = append(rejects, OversizeFile{Request: req, Size: size})
+			}
+			continue
+		}
+		if oversize && !zi.Zip64 && size > zip32MaxSize-zi.splitFixedSize() {
+			return fail(fmt.Errorf("%v [%v] needs zip64 to be isolated, but zip64 is turned off",
+				rowLabel(req.SheetName, req.RowNumber), req.FileName))
+		}
+		// the entry rolls into the next split, an isolated one always takes a split of its own
+		if len(ms.split.requests) > 0 && (oversize || ms.used+size > maxSize) {
+			if err = finish(); err == nil {
+				ms, err = zi.openMeasuredSplit(stagingDir, len(splits)+1)
+			}
+			if err != nil {
+				return fail(err)
+			}
+		}
+		ms.split.oversize = oversize
+		err = spool.appendTo(ms)
+		spool.remove()
+		spool = nil
+		if err == nil {
+			err = zi.addMeasuredEntry(ms, req, size)
+		}
+		if err == nil && oversize {
+			err = finish()
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if len(failed) > 0 {
+		return fail(&OversizeError{MaxSize: maxSize, Files: failed})
+	}
+	if ms != nil && len(ms.split.requests) == 0 {
+		// every request after the last split was rejected
+		_ = ms.file.Close()
+		zi.removeSplitOutput(stagingDir, ms.split)
+	} else if ms != nil {
+		if err := finish(); err != nil {
+			return fail(err)
+		}
+	}
+	return splits, rejects, nil
+}
+
+func (zi *ZipInstruction) openMeasuredSplit(stagingDir string, seq int) (*measuredSplit, error) {
+	split := zipSplit{seq: seq, fileName: measuredDir + "/split-" + strconv.Itoa(seq)}
+	// leftovers of a failed run are rebuilt from scratch
+	zi.removeSplitOutput(stagingDir, split)
+	f, err := os.Create(stagingDir + "/" + split.fileName + zi.archiveExt())
+	if err != nil {
+		return nil, err
+	}
+	ms := &measuredSplit{
+		split:  split,
+		file:   f,
+		h:      sha256.New(),
+		used:   zi.splitFixedSize(),
+		unzipD: stagingDir + "/" + split.fileName + ".d",
+	}
+	ms.out = io.MultiWriter(f, ms.h)
+	if !zi.isTar() {
+		ms.zw = zip.NewWriter(ms.out)
+		zi.Compression.registerCompressor(ms.zw)
+	}
+	if zi.Unzip && zi.UnzipMode != UnzipExtract {
+		if err = os.Mkdir(ms.unzipD, 0755); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return ms, nil
+}
+
+// writeMeasuredEntry writes a request into the split directly, and returns the size of its entry
+func (zi *ZipInstruction) writeMeasuredEntry(ms *measuredSplit, req *model.Request) (int64, error) {
+	sink := &entrySink{zi: zi, w: &countingWriter{w: ms.out}, zw: ms.zw}
+	if err := zi.doZipFile(sink, req); err != nil {
+		return 0, err
+	}
+	if err := sink.Close(); err != nil {
+		return 0, err
+	}
+	return sink.size(), nil
+}
+
+// addMeasuredEntry takes a request written into the split into account, and mirrors it into the unzip dir
+func (zi *ZipInstruction) addMeasuredEntry(ms *measuredSplit, req *model.Request, size int64) error {
+	ms.used += size
+	ms.split.requests = append(ms.split.requests, *req)
+	if zi.Unzip && zi.UnzipMode != UnzipExtract {
+		return zi.doMirrorSourceFile(*req, ms.unzipD)
+	}
+	return nil
+}
+
+// finishMeasuredSplit writes the metadata xml, closes the split and reads it back if asked for.
+// It returns the sha256 of the archive
+func (zi *ZipInstruction) finishMeasuredSplit(ms *measuredSplit) (string, error) {
+	var zipWriter archiveWriter = &zipArchiveWriter{zi: zi, zw: ms.zw}
+	var err error
+	if zi.isTar() {
+		// the metadata xml and the end of the tar go into a last compressed stream
+		zipWriter, err = zi.newArchiveWriter(ms.out)
+	}
+	if err == nil {
+		err = zi.doZipSplitMetaXml(zipWriter, ms.split, ms.unzipD)
+		if closeE := zipWriter.Close(); err == nil {
+			err = closeE
+		}
+	}
+	if closeE := ms.file.Close(); err == nil {
+		err = closeE
+	}
+	if err == nil {
+		err = zi.checkSplitArchive(ms.split, ms.file.Name(), ms.unzipD)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ms.h.Sum(nil)), nil
+}
+
+// nameMeasuredSplits moves the splits from their provisional names to their target file names, and writes their
+// sidecars. Splits named by a failed run keep their names, since their count is final once they are named
+func (zi *ZipInstruction) nameMeasuredSplits(manifest *RunManifest, splits []zipSplit) error {
+	stagingDir := manifest.stagingDir()
+	tm := time.Now()
+	fileNames := make([]string, len(splits))
+	for i, split := range splits {
+		if !strings.HasPrefix(split.fileName, measuredDir+"/") {
+			fileNames[i] = split.fileName
+			continue
+		}
+		fileName, err := zi.resolveTargetFileName(splitNameVars{tm: tm, seq: split.seq, count: len(splits), requestCount: len(split.requests)})
+		if err != nil {
+			return err
+		}
+		fileNames[i] = fileName
+	}
+	for i, fileName := range zi.uniqueTargetFileNames(fileNames) {
+		if fileName != splits[i].fileName {
+			from := stagingDir + "/" + splits[i].fileName
+			to := stagingDir + "/" + fileName
+			if err := os.Rename(from+zi.archiveExt(), to+zi.archiveExt()); err != nil {
+				return err
+			}
+			if _, err := os.Stat(from + ".d"); err == nil {
+				if err = os.Rename(from+".d", to+".d"); err != nil {
+					return err
+				}
+			}
+			// sidecars of the old name would point to no archive
+			for _, sidecar := range zi.sidecarFileNames(splits[i].fileName) {
+				_ = os.Remove(stagingDir + "/" + sidecar)
+			}
+		}
+		splits[i].fileName = fileName
+		splits[i].count = len(splits)
+		manifest.Splits[i].FileName = fileName
+		if zi.Sidecar {
+			if err := zi.writeSidecars(splits[i], stagingDir, manifest.Splits[i].Sha256); err != nil {
+				return err
+			}
+		}
+	}
+	// a resume keeps the renamed splits
+	if err := manifest.save(); err != nil {
+		return err
+	}
+	return os.Remove(stagingDir + "/" + measuredDir)
+}
+
+// entrySink writes one entry of a measured split, either into the split or into a spool, and tells the size of
+// the entry in the archive once it is closed. A zip entry is compressed by the sink itself, and a tar entry is
+// a compressed stream of its own
+type entrySink struct {
+	zi  *ZipInstruction
+	w   *countingWriter
+	zw  *zip.Writer // the split to add a zip entry to, or nil to write the entry to w
+	fh  *zip.FileHeader
+	tar *tarArchiveWriter
+}
+
+func (s *entrySink) createEntry(name string, mimeType string, size int64) (io.WriteCloser, error) {
+	zi := s.zi
+	if zi.isTar() {
+		aw, err := zi.newArchiveWriter(s.w)
+		if err != nil {
+			return nil, err
+		}
+		s.tar = aw.(*tarArchiveWriter)
+		return s.tar.createEntry(name, mimeType, size)
+	}
+	method := zi.Compression.methodOf(name, mimeType)
+	if zi.Encryption == EncryptionAES256 {
+		s.fh = aesFileHeader(name, method)
+	} else {
+		s.fh = rawFileHeader(name, method)
+	}
+	var raw io.Writer = s.w
+	if s.zw != nil {
+		var err error
+		if raw, err = s.zw.CreateRaw(s.fh); err != nil {
+			return nil, err
+		}
+	}
+	if zi.Encryption == EncryptionAES256 {
+		return newAesEntryWriter(s.fh, raw, method, &zi.Compression, zi.EncryptionPassword)
+	}
+	return newZipRawEntryWriter(s.fh, raw, &zi.Compression), nil
+}
+
+// Close ends the compressed stream of a tar entry
+func (s *entrySink) Close() error {
+	if s.tar != nil {
+		return s.tar.closeMember()
+	}
+	return nil
+}
+
+func (s *entrySink) size() int64 {
+	if s.tar != nil {
+		return s.w.n
+	}
+	return s.zi.zipEntryOverhead(s.fh.Name) + int64(s.fh.CompressedSize64)
+}
+
+// entrySpool is an entry written into a temporary file of the staging dir, to be copied into a split as it is
+type entrySpool struct {
+	file *os.File
+	sink *entrySink
+}
+
+func (zi *ZipInstruction) spoolEntry(stagingDir string, req *model.Request) (*entrySpool, error) {
+	f, err := os.CreateTemp(stagingDir+"/"+measuredDir, "spool-")
+	if err != nil {
+		return nil, err
+	}
+	spool := &entrySpool{file: f, sink: &entrySink{zi: zi, w: &countingWriter{w: f}}}
+	err = zi.doZipFile(spool.sink, req)
+	if err == nil {
+		err = spool.sink.Close()
+	}
+	if err != nil {
+		spool.remove()
+		return nil, err
+	}
+	return spool, nil
+}
+
+func (s *entrySpool) appendTo(ms *measuredSplit) error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w := ms.out
+	if ms.zw != nil {
+		var err error
+		if w, err = ms.zw.CreateRaw(s.sink.fh); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(w, s.file)
+	return err
+}
+
+func (s *entrySpool) remove() {
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zip-pkg-in-go/model"
+)
+
+// countingSource counts how often source files are opened
+type countingSource struct {
+	LocalSource
+	opens atomic.Int32
+}
+
+func (s *countingSource) Open(path string) (io.ReadCloser, error) {
+	s.opens.Add(1)
+	return s.LocalSource.Open(path)
+}
+
+func TestZipMeasuredRollsOverflowIntoNextSplit(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10000, 7000, 12000, 60000, 3000, 25000, 9000, 10000})
+	rnd := rand.New(rand.NewSource(1))
+	for _, req := range requests {
+		// incompressible content, except f-4, whose bound alone exceeds max size, but which compresses well
+		if req.FileName == "f-4.pdf" {
+			continue
+		}
+		data := make([]byte, len(readFile(t, srcDir+"/"+req.FileName)))
+		rnd.Read(data)
+		if err := os.WriteFile(srcDir+"/"+req.FileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		format     string
+		encryption string
+	}{
+		{ArchiveZip, EncryptionNone},
+		{ArchiveZip, EncryptionAES256},
+		{ArchiveTarGz, EncryptionNone},
+		{ArchiveTarZst, EncryptionNone},
+	}
+	for _, tt := range tests {
+		for _, maxSize := range []int64{20000, 33000} {
+			t.Run(tt.format+"-"+tt.encryption+"-"+strconv.FormatInt(maxSize, 10), func(t *testing.T) {
+				source := &countingSource{LocalSource: LocalSource{Dir: srcDir}}
+				zi := newTestZipInstruction(t, srcDir)
+				zi.Source = source
+				zi.MaxSize = maxSize
+				zi.ArchiveFormat = tt.format
+				zi.Encryption = tt.encryption
+				zi.EncryptionPassword = "s3cret"
+				zi.OversizePolicy = OversizeSkip
+				zi.MeasureCompressed = true
+				zi.TargetFileNamePattern = "package-${splitSeq}-of-${splitCount}"
+				splitRequests := append([]model.Request(nil), requests...)
+				summary, err := zi.Zip(&splitRequests)
+				if err != nil {
+					t.Fatalf("Zip failed: %v", err)
+				}
+				if int(source.opens.Load()) != len(requests) {
+					t.Errorf("expect every source file to be read once, got %v opens", source.opens.Load())
+				}
+				// f-6 only fits into the larger max size
+				wantRejects := 0
+				if maxSize < 30000 {
+					wantRejects = 1
+				}
+				if len(summary.Rejects) != wantRejects || summary.RequestCount+wantRejects != len(requests) {
+					t.Fatalf("unexpected summary %+v", summary)
+				}
+				entries := make([]string, 0)
+				for i := 1; i <= summary.SplitCount; i++ {
+					archiveFile := zi.DstDir + "/package-" + strconv.Itoa(i) + "-of-" + strconv.Itoa(summary.SplitCount) + zi.archiveExt()
+					info, err := os.Stat(archiveFile)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if info.Size() > maxSize {
+						t.Errorf("split %v has %v bytes, exceeds max size %v", i, info.Size(), maxSize)
+					}
+					err = zi.walkArchive(archiveFile, func(name string, r io.Reader) error {
+						data, err := io.ReadAll(r)
+						if err != nil || name == zi.MetaXmlFileName {
+							return err
+						}
+						if !bytes.Equal(data, readFile(t, srcDir+"/"+name)) {
+							t.Errorf("%v in split %v differs from source", name, i)
+						}
+						entries = append(entries, name)
+						return nil
+					})
+					if err != nil {
+						t.Fatalf("read split %v failed: %v", i, err)
+					}
+				}
+				want := []string{"f-1.pdf", "f-2.pdf", "f-3.pdf", "f-4.pdf", "f-5.pdf", "f-6.pdf", "f-7.pdf", "f-8.pdf"}
+				if wantRejects > 0 {
+					want = append(want[:5], want[6:]...)
+				}
+				if !reflect.DeepEqual(entries, want) {
+					t.Errorf("got entries %v, want %v", entries, want)
+				}
+			})
+		}
+	}
+}
+
+func TestZipMeasuredResume(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10000, 10000, 10000, 10000})
+	rnd := rand.New(rand.NewSource(1))
+	for _, req := range requests {
+		data := make([]byte, 10000)
+		rnd.Read(data)
+		if err := os.WriteFile(srcDir+"/"+req.FileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// f-4 fails to be zipped once f-1 and f-2 are done, and f-3 rolled into the next split
+	data := readFile(t, srcDir+"/f-4.pdf")
+	_ = os.Remove(srcDir + "/f-4.pdf")
+	_ = os.Mkdir(srcDir+"/f-4.pdf", 0755)
+	zi := newTestZipInstruction(t, srcDir)
+	zi.Unzip = true
+	zi.MaxSize = 25000
+	zi.MeasureCompressed = true
+	zi.TargetFileNamePattern = "package-${splitSeq}-of-${splitCount}"
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Fatalf("expect Zip to fail")
+	}
+	names := dirEntryNames(t, zi.DstDir)
+	manifestFile := zi.DstDir + "/" + names[1]
+	manifest, err := LoadRunManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Measured || len(manifest.Splits) != 1 || len(manifest.Splits[0].Requests) != 2 {
+		t.Fatalf("expect split 1 of f-1 and f-2 in a measured manifest, got %+v", manifest)
+	}
+	stagingInfo, err := os.Stat(manifest.stagingDir() + "/" + manifest.Splits[0].FileName + ".zip")
+	if err != nil {
+		t.Fatalf("expect the done split in the staging dir: %v", err)
+	}
+	if leftovers := dirEntryNames(t, manifest.stagingDir()+"/"+measuredDir); len(leftovers) != 2 {
+		t.Errorf("expect only split 1 and its unzip dir to be left, got %v", leftovers)
+	}
+
+	_ = os.Remove(srcDir + "/f-4.pdf")
+	_ = os.WriteFile(srcDir+"/f-4.pdf", data, 0644)
+	zi2 := NewZipInstruction()
+	zi2.SrcDir = srcDir
+	zi2.MaxSize = 25000
+	zi2.TargetFileNamePattern = "package-${splitSeq}-of-${splitCount}"
+	summary, err := zi2.Resume(&requests, manifestFile)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if summary.SplitCount != 2 || summary.RequestCount != 4 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	names = dirEntryNames(t, zi.DstDir)
+	want := []string{"package-1-of-2.d", "package-1-of-2.zip", "package-2-of-2.d", "package-2-of-2.zip"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	info, _ := os.Stat(zi.DstDir + "/package-1-of-2.zip")
+	if !os.SameFile(info, stagingInfo) {
+		t.Errorf("expect split 1 of the failed run to be kept")
+	}
+	got := readZipEntries(t, zi.DstDir, []string{"package-1-of-2", "package-2-of-2"})
+	wantEntries := [][]string{{"f-1.pdf", "f-2.pdf", "package-metadata.xml"}, {"f-3.pdf", "f-4.pdf", "package-metadata.xml"}}
+	if !reflect.DeepEqual(got, wantEntries) {
+		t.Errorf("got %v, want %v", got, wantEntries)
+	}
+
+	zi3 := NewZipInstruction()
+	zi3.MeasureCompressed = true
+	zi3.SplitStrategy = SplitBestFit
+	_, err = zi3.zipMeasured(requests)
+	if err == nil || !strings.Contains(err.Error(), SplitBestFit) {
+		t.Errorf("expect error for split strategy %v, got %v", SplitBestFit, err)
+	}
+}
+
+func TestZipMeasuredResumeAfterNaming(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10000, 10000, 10000, 30000})
+	rnd := rand.New(rand.NewSource(1))
+	for _, req := range requests {
+		data := make([]byte, len(readFile(t, srcDir+"/"+req.FileName)))
+		rnd.Read(data)
+		if err := os.WriteFile(srcDir+"/"+req.FileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newInstruction := func(dstDir string) *ZipInstruction {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.DstDir = dstDir
+		zi.MaxSize = 25000
+		zi.MeasureCompressed = true
+		zi.Sidecar = true
+		zi.OversizePolicy = OversizeSkip
+		zi.TargetFileNamePattern = "package-${yyMMddHHmmssSSS}-${splitSeq}"
+		return zi
+	}
+	// the splits are named and have their sidecars, when the rejects report of f-4 fails to be written
+	zi := newInstruction(t.TempDir())
+	zi.RejectsFileName = "missing/rejects.csv"
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Fatalf("expect Zip to fail")
+	}
+	manifestFile := zi.DstDir + "/" + dirEntryNames(t, zi.DstDir)[1]
+	time.Sleep(10 * time.Millisecond)
+	summary, err := newInstruction("").Resume(&requests, manifestFile)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if summary.SplitCount != 2 || len(summary.Rejects) != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	names := dirEntryNames(t, zi.DstDir)
+	archives := 0
+	for _, name := range names {
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".manifest.json"), ".zip.sha256")
+		if base != name {
+			if _, err = os.Stat(zi.DstDir + "/" + base + ".zip"); err != nil {
+				t.Errorf("sidecar %v points to no archive", name)
+			}
+		} else if strings.HasSuffix(name, ".zip") {
+			archives++
+		}
+	}
+	if archives != 2 || len(names) != 2*3+1 {
+		t.Errorf("expect 2 splits with 2 sidecars each and the rejects report, got %v", names)
+	}
+}
+
+func TestZipMeasuredOversizeFailListsEveryFile(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{3000, 60000, 3000, 70000, 3000})
+	rnd := rand.New(rand.NewSource(1))
+	for _, req := range requests {
+		data := make([]byte, len(readFile(t, srcDir+"/"+req.FileName)))
+		rnd.Read(data)
+		if err := os.WriteFile(srcDir+"/"+req.FileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MaxSize = 50000
+	zi.MeasureCompressed = true
+	_, err := zi.Zip(&requests)
+	var oversizeErr *OversizeError
+	if !errors.As(err, &oversizeErr) || len(oversizeErr.Files) != 2 || oversizeErr.Files[0].Request.FileName != "f-2.pdf" || oversizeErr.Files[1].Request.FileName != "f-4.pdf" {
+		t.Errorf("expect oversize error listing f-2.pdf and f-4.pdf, got %v", err)
+	}
+	if names := dirEntryNames(t, zi.DstDir); len(names) != 0 {
+		t.Errorf("expect no output, got %v", names)
+	}
+}
+
+func TestZipMeasuredResumeKeepsRunID(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{1000, 1000, 1000})
+	// f-3 fails to be zipped, so that no split is named before the resume
+	_ = os.Remove(srcDir + "/f-3.pdf")
+	_ = os.Mkdir(srcDir+"/f-3.pdf", 0755)
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MeasureCompressed = true
+	zi.TargetFileNamePattern = "package-${runId}-${splitSeq}"
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Fatalf("expect Zip to fail")
+	}
+	manifestFile := zi.DstDir + "/" + dirEntryNames(t, zi.DstDir)[1]
+	manifest, err := LoadRunManifest(manifestFile)
+	if err != nil || manifest.RunID != zi.RunID || zi.RunID == "" {
+		t.Fatalf("expect run ID %v in the manifest, got %+v, %v", zi.RunID, manifest, err)
+	}
+
+	_ = os.Remove(srcDir + "/f-3.pdf")
+	_ = os.WriteFile(srcDir+"/f-3.pdf", []byte("%PDF-1.4"), 0644)
+	zi2 := newTestZipInstruction(t, srcDir)
+	zi2.TargetFileNamePattern = zi.TargetFileNamePattern
+	if _, err = zi2.Resume(&requests, manifestFile); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	names := dirEntryNames(t, zi.DstDir)
+	want := []string{"package-" + zi.RunID + "-1.zip"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// what to do with a source file which alone does not fit into a split of max size
+const (
+	OversizeFail    = "fail"
+	OversizeIsolate = "isolate"
+	OversizeSkip    = "skip"
+)
+
+type OversizeFile struct {
+	Request *model.Request
+	Size    int64
+}
+
+type OversizeError struct {
+	MaxSize int64
+	Files   []OversizeFile
+}
+
+func (e *OversizeError) Error() string {
+	names := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		names = append(names, rowLabel(f.Request.SheetName, f.Request.RowNumber)+" ["+f.Request.FileName+"] needs "+
+			strconv.FormatInt(f.Size, 10)+" bytes")
+	}
+	return strconv.Itoa(len(e.Files)) + " file(s) larger than max size " + strconv.FormatInt(e.MaxSize, 10) + ": " + strings.Join(names, "; ")
+}
+
+func checkOversizePolicy(policy string) error {
+	if policy == OversizeFail || policy == OversizeIsolate || policy == OversizeSkip {
+		return nil
+	}
+	return errors.New("unknown oversize policy [" + policy + "]")
+}
+
+// uniqueRejectsFileName suffixes the name of the rejects report with -2, -3 and so on before its extension,
+// when it is taken in the output dir, e.g. by the report of an earlier run
+func (zi *ZipInstruction) uniqueRejectsFileName() string {
+	ext := filepath.Ext(zi.RejectsFileName)
+	base := strings.TrimSuffix(zi.RejectsFileName, ext)
+	fileName := zi.RejectsFileName
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(zi.DstDir + "/" + fileName); err != nil {
+			return fileName
+		}
+		fileName = base + "-" + strconv.Itoa(n) + ext
+	}
+}
+
+func (zi *ZipInstruction) writeRejectsReport(rejects []OversizeFile, outDir string, fileName string) error {
+	f, err := os.Create(outDir + "/" + fileName)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"SheetName", "RowNumber", "ID", "FileName", "Size", "MaxSize", "Reason"})
+	for _, reject := range rejects {
+		_ = w.Write([]string{
+			reject.Request.SheetName,
+			strconv.Itoa(reject.Request.RowNumber),
+			reject.Request.ID,
+			reject.Request.FileName,
+			strconv.FormatInt(reject.Size, 10),
+			strconv.FormatInt(zi.effectiveMaxSize(), 10),
+			"larger than max size",
+		})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
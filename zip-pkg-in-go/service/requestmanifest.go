@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// RequestManifestSchema is the JSON schema of a request manifest, which is model.Pkg in JSON or YAML,
+// with a GroupId in a TagGroup for groups of the same name
+//
+//go:embed schema/request-manifest.schema.json
+var RequestManifestSchema string
+
+var requestManifestSchema = jsonschema.MustCompileString("request-manifest.schema.json", RequestManifestSchema)
+
+type manifestPkg struct {
+	ID       string
+	Header   model.PkgHeader
+	Requests []manifestRequest
+	Trailer  *model.PkgTrailer
+}
+
+type manifestRequest struct {
+	ID       string
+	FileName string
+	MimeType string
+	DocName  string
+	Metadata *struct {
+		Tags      []model.Tag
+		TagGroups []struct {
+			GroupId   string
+			GroupName string
+			Tags      []model.Tag
+		}
+	}
+}
+
+func isRequestManifest(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// parseRequestManifest reads a JSON or YAML manifest. Row numbers are the 1-based positions of the requests,
+// and IDs, DocNames and MimeTypes default the same way as for the rows of a sheet
+func (pi *ParseInstruction) parseRequestManifest(file string) (*model.Pkg, *[]ColHeader, error) {
+	mp, err := readRequestManifest(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := &model.Pkg{ID: mp.ID, Header: mp.Header}
+	ret.Requests = make([]model.Request, 0, len(mp.Requests))
+	for i, mr := range mp.Requests {
+		req := model.Request{
+			RowNumber: i + 1,
+			ID:        mr.ID,
+			FileName:  strings.TrimSpace(mr.FileName),
+			MimeType:  mr.MimeType,
+			DocName:   mr.DocName,
+			Metadata:  &model.Metadata{},
+		}
+		if req.ID == "" {
+			req.ID = strconv.Itoa(i + 1)
+		}
+		if req.DocName == "" && pi.defaultDocName != "" {
+			req.DocName = pi.defaultDocName
+		}
+		if req.MimeType == "" && pi.defaultMimeType != "" {
+			req.MimeType = pi.defaultMimeType
+		}
+		if mr.Metadata != nil {
+			for _, tag := range mr.Metadata.Tags {
+				req.Metadata.AddTagOrGroupTag("", "", tag.Name, tag.Value)
+			}
+			for _, group := range mr.Metadata.TagGroups {
+				for _, tag := range group.Tags {
+					req.Metadata.AddTagOrGroupTag(group.GroupId, group.GroupName, tag.Name, tag.Value)
+				}
+			}
+		}
+		ret.Requests = append(ret.Requests, req)
+	}
+	ret.Trailer.RequestCount = len(ret.Requests)
+	return ret, pi.manifestHeaders(mp), nil
+}
+
+func readRequestManifest(file string) (*manifestPkg, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".yaml" || ext == ".yml" {
+		var node yaml.Node
+		if err = yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		doc, err := yamlValue(&node)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, errors.New("request manifest [" + file + "] cannot be mapped to JSON: " + err.Error())
+		}
+	}
+	var doc interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err = requestManifestSchema.Validate(doc); err != nil {
+		return nil, errors.New("request manifest [" + file + "] does not match the schema: " + err.Error())
+	}
+	mp := manifestPkg{}
+	if err = json.NewDecoder(bytes.NewReader(data)).Decode(&mp); err != nil {
+		return nil, err
+	}
+	if mp.Trailer != nil && mp.Trailer.RequestCount != len(mp.Requests) {
+		return nil, errors.New("request manifest [" + file + "] has " + strconv.Itoa(len(mp.Requests)) +
+			" requests, but a RequestCount of " + strconv.Itoa(mp.Trailer.RequestCount))
+	}
+	return &mp, nil
+}
+
+// yamlValue is like decoding into an interface{}, except that a date stays as written instead of becoming a time
+func yamlValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.MappingNode:
+		ret := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := yamlValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			ret[node.Content[i].Value] = value
+		}
+		return ret, nil
+	case yaml.SequenceNode:
+		ret := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, value)
+		}
+		return ret, nil
+	}
+	if node.ShortTag() == "!!timestamp" {
+		return node.Value, nil
+	}
+	var ret interface{}
+	err := node.Decode(&ret)
+	return ret, err
+}
+
+// manifestHeaders stands in for the header row of a sheet, with a column for every tag in the order they first appear
+func (pi *ParseInstruction) manifestHeaders(mp *manifestPkg) *[]ColHeader {
+	headers := make([]ColHeader, 0)
+	seen := make(map[string]bool)
+	add := func(rawName string) {
+		if !seen[rawName] {
+			seen[rawName] = true
+			headers = append(headers, *pi.parseColHeader(len(headers), rawName))
+		}
+	}
+	add("RefID")
+	add("FileName")
+	add("MimeType")
+	add("DocName")
+	for _, mr := range mp.Requests {
+		if mr.Metadata != nil {
+			for _, tag := range mr.Metadata.Tags {
+				add(tag.Name)
+			}
+		}
+	}
+	for _, mr := range mp.Requests {
+		if mr.Metadata == nil {
+			continue
+		}
+		for _, group := range mr.Metadata.TagGroups {
+			groupName := group.GroupName
+			if group.GroupId != "" {
+				groupName = group.GroupId + pi.groupIdNameDelimiter + groupName
+			}
+			for _, tag := range group.Tags {
+				add(pi.groupPrefix + groupName + pi.groupSuffix + " " + tag.Name)
+			}
+		}
+	}
+	return &headers
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequestManifest(t *testing.T) {
+	testFileWith(t, NewParseInstruction(), "../testdata/excel/pkg-test.json", nil)
+	testFileWith(t, NewParseInstruction(), "../testdata/excel/pkg-test.yaml", nil)
+
+	pi := NewParseInstruction()
+	want := []string{"RefID", "FileName", "MimeType", "DocName", "FirstName", "LastName", "DOB", "[IssueInfo] IssueDate",
+		"[IssueInfo] ExpiryDate", "[IssueInfo] IssuePlace", "[2:IssueInfo] IssuePlace", "[ContactInfo] Phone",
+		"[ContactInfo] Email", "[IssueInfo] Grade"}
+	got := make([]string, 0)
+	for _, header := range *pi.ExtractRequestHeaders("../testdata/excel/pkg-test.json") {
+		got = append(got, header.RawName)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got headers %v, want %v", got, want)
+	}
+}
+
+func TestParseRequestManifestRejectsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing.json", `{"Requests": [{"ID": "1"}]}`, "FileName"},
+		{"unknown.json", `{"Requests": [{"FileName": "a.pdf", "Size": 1}]}`, "Size"},
+		{"number.yaml", "Requests:\n- FileName: a.pdf\n  ID: 1\n", "string"},
+		{"count.yaml", "Requests:\n- FileName: a.pdf\nTrailer:\n  RequestCount: 2\n", "RequestCount"},
+		{"syntax.json", `{"Requests": [`, "unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(dir+"/"+tt.name, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := NewParseInstruction().ParsePackageRequests(dir + "/" + tt.name)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one about %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// RequestSource reads the rows of a metadata sheet, the header row first. ReadRow returns io.EOF after the last row
+type RequestSource interface {
+	ReadRow() ([]string, error)
+	Close() error
+}
+
+// quoting of csv and tsv files: standard is RFC 4180, lazy also takes a quote inside an unquoted field,
+// and none reads quotes as plain characters
+const (
+	CsvQuoteStandard = "standard"
+	CsvQuoteLazy     = "lazy"
+	CsvQuoteNone     = "none"
+)
+
+// OpenRequestSource picks the reader by extension: .csv and .tsv are delimited text, anything else is an excel workbook
+func (pi *ParseInstruction) OpenRequestSource(file string) (RequestSource, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return pi.openCsvSource(file, ",")
+	case ".tsv", ".tab":
+		return pi.openCsvSource(file, "\t")
+	}
+	return pi.openExcelSource(file)
+}
+
+// excelRequestSource streams the rows of a sheet, so that a large sheet is never loaded as a whole
+type excelRequestSource struct {
+	f    *excelize.File
+	rows *excelize.Rows
+}
+
+func (pi *ParseInstruction) openExcelSource(xlsx string) (*excelRequestSource, error) {
+	f, err := excelize.OpenFile(xlsx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := f.Rows(pi.SheetName)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &excelRequestSource{f: f, rows: rows}, nil
+}
+
+func (s *excelRequestSource) ReadRow() ([]string, error) {
+	if !s.rows.Next() {
+		if s.rows.Error() != nil {
+			return nil, s.rows.Error()
+		}
+		return nil, io.EOF
+	}
+	return s.rows.Columns()
+}
+
+func (s *excelRequestSource) Close() error {
+	err := s.rows.Close()
+	if err2 := s.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+type csvRequestSource struct {
+	f    *os.File
+	read func() ([]string, error)
+}
+
+func (pi *ParseInstruction) openCsvSource(file string, defaultDelimiter string) (*csvRequestSource, error) {
+	delimiter, err := csvDelimiter(pi.CsvDelimiter, defaultDelimiter)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := htmlindex.Get(pi.CsvEncoding)
+	if err != nil {
+		return nil, errors.New("unknown csv encoding [" + pi.CsvEncoding + "]")
+	}
+	if pi.CsvQuoting != CsvQuoteStandard && pi.CsvQuoting != CsvQuoteLazy && pi.CsvQuoting != CsvQuoteNone {
+		return nil, errors.New("unknown csv quoting [" + pi.CsvQuoting + "]")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	// a byte order mark wins over the configured encoding, and is dropped
+	r := transform.NewReader(f, unicode.BOMOverride(enc.NewDecoder()))
+	s := &csvRequestSource{f: f}
+	if pi.CsvQuoting == CsvQuoteNone {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		s.read = func() ([]string, error) {
+			if !scanner.Scan() {
+				if scanner.Err() != nil {
+					return nil, scanner.Err()
+				}
+				return nil, io.EOF
+			}
+			return strings.Split(strings.TrimSuffix(scanner.Text(), "\r"), string(delimiter)), nil
+		}
+		return s, nil
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = pi.CsvQuoting == CsvQuoteLazy
+	// the csv reader skips blank lines, which are given back as empty rows, so that row numbers and
+	// the empty row limit are the same as in a sheet
+	nextLine := 1
+	var pending []string
+	s.read = func() ([]string, error) {
+		if pending == nil {
+			record, err := cr.Read()
+			if err != nil {
+				return nil, err
+			}
+			pending = record
+		}
+		line, _ := cr.FieldPos(0)
+		if line > nextLine {
+			nextLine++
+			return []string{}, nil
+		}
+		record := pending
+		pending = nil
+		nextLine = line + 1
+		for _, field := range record {
+			nextLine += strings.Count(field, "\n")
+		}
+		return record, nil
+	}
+	return s, nil
+}
+
+// csvDelimiter takes a single character, or tab written as \t or tab, since a properties value is trimmed
+func csvDelimiter(configured string, defaultDelimiter string) (rune, error) {
+	if configured == "" {
+		configured = defaultDelimiter
+	}
+	if configured == `\t` || strings.EqualFold(configured, "tab") {
+		configured = "\t"
+	}
+	r, size := utf8.DecodeRuneInString(configured)
+	if size != len(configured) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, errors.New("csv delimiter [" + configured + "] must be a single character other than quote or line break")
+	}
+	return r, nil
+}
+
+func (s *csvRequestSource) ReadRow() ([]string, error) {
+	return s.read()
+}
+
+func (s *csvRequestSource) Close() error {
+	return s.f.Close()
+}
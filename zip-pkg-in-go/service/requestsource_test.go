@@ -0,0 +1,77 @@
+package service
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParsePackageCsvAndTsv(t *testing.T) {
+	testFileWith(t, NewParseInstruction(), "../testdata/excel/pkg-test.csv", nil)
+	// with a byte order mark and a blank line after the header
+	testFileWith(t, NewParseInstruction(), "../testdata/excel/pkg-test.tsv", nil)
+}
+
+func TestCsvRequestSource(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name      string
+		content   string
+		delimiter string
+		quoting   string
+		encoding  string
+		want      [][]string
+		wantErr   bool
+	}{
+		{"rows", "a,b\n\n\"x\ny\",z\n1,2\n", "", CsvQuoteStandard, "utf-8",
+			[][]string{{"a", "b"}, {}, {"x\ny", "z"}, {"1", "2"}}, false},
+		{"semicolon", "a;b\r\n1;2\r\n", ";", CsvQuoteStandard, "utf-8", [][]string{{"a", "b"}, {"1", "2"}}, false},
+		{"latin1", "Caf\xe9,Ol\xe9\n", "", CsvQuoteStandard, "iso-8859-1", [][]string{{"Café", "Olé"}}, false},
+		{"lazy", "a\"b,c\n", "", CsvQuoteLazy, "utf-8", [][]string{{"a\"b", "c"}}, false},
+		{"strict", "a\"b,c\n", "", CsvQuoteStandard, "utf-8", nil, true},
+		{"none", "\"a,b\"\n", "", CsvQuoteNone, "utf-8", [][]string{{"\"a", "b\""}}, false},
+		{"bad delimiter", "a\n", "ab", CsvQuoteStandard, "utf-8", nil, true},
+		{"bad encoding", "a\n", "", CsvQuoteStandard, "nope", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := dir + "/" + tt.name + ".csv"
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			pi := NewParseInstruction()
+			pi.CsvDelimiter = tt.delimiter
+			pi.CsvQuoting = tt.quoting
+			pi.CsvEncoding = tt.encoding
+			got, err := readAllRows(pi, file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func readAllRows(pi *ParseInstruction, file string) ([][]string, error) {
+	src, err := pi.OpenRequestSource(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	rows := make([][]string, 0)
+	for {
+		row, err := src.ReadRow()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
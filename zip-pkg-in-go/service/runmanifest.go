@@ -0,0 +1,206 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"zip-pkg-in-go/model"
+)
+
+// RunManifest records the split plan of a packaging run and the splits done so far, so that a failed run
+// can be resumed. It lives in the output dir next to the staging dir, and is removed once the run is committed
+type RunManifest struct {
+	StagingDir    string            `json:"stagingDir"` // relative to the dir of the manifest
+	ArchiveFormat string            `json:"archiveFormat,omitempty"`
+	RunID         string            `json:"runId,omitempty"` // ${runId} of the run, which a resume names its splits with
+	Measured      bool              `json:"measured,omitempty"` // splits are added once written, see MeasureCompressed
+	Splits        []ManifestSplit   `json:"splits"`
+	Rejects       []ManifestRequest `json:"rejects,omitempty"`
+	RejectsFile   string            `json:"rejectsFile,omitempty"` // the unique name of the rejects report
+	path          string
+	mu            sync.Mutex
+}
+
+type ManifestSplit struct {
+	Seq      int               `json:"seq"`
+	FileName string            `json:"fileName"`
+	Oversize bool              `json:"oversize,omitempty"`
+	Requests []ManifestRequest `json:"requests"`
+	Done     bool              `json:"done"`
+	Sha256   string            `json:"sha256,omitempty"`
+}
+
+type ManifestRequest struct {
+	SheetName string `json:"sheetName,omitempty"`
+	RowNumber int    `json:"rowNumber"`
+	ID        string `json:"id"`
+	FileName  string `json:"fileName"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+func newRunManifest(stagingDir string, archiveFormat string, runID string, splits []zipSplit, rejects []OversizeFile) *RunManifest {
+	suffix := strings.TrimPrefix(filepath.Base(stagingDir), ".staging")
+	m := &RunManifest{
+		StagingDir:    filepath.Base(stagingDir),
+		ArchiveFormat: archiveFormat,
+		RunID:         runID,
+		Splits:        make([]ManifestSplit, 0, len(splits)),
+		Rejects:       make([]ManifestRequest, 0, len(rejects)),
+		path:          filepath.Dir(stagingDir) + "/run-manifest" + suffix + ".json",
+	}
+	for _, split := range splits {
+		m.Splits = append(m.Splits, newManifestSplit(split))
+	}
+	for _, reject := range rejects {
+		m.Rejects = append(m.Rejects, ManifestRequest{SheetName: reject.Request.SheetName,
+			RowNumber: reject.Request.RowNumber, ID: reject.Request.ID, FileName: reject.Request.FileName, Size: reject.Size})
+	}
+	return m
+}
+
+func newManifestSplit(split zipSplit) ManifestSplit {
+	ms := ManifestSplit{
+		Seq:      split.seq,
+		FileName: split.fileName,
+		Oversize: split.oversize,
+		Requests: make([]ManifestRequest, 0, len(split.requests)),
+	}
+	for _, req := range split.requests {
+		ms.Requests = append(ms.Requests, ManifestRequest{SheetName: req.SheetName, RowNumber: req.RowNumber,
+			ID: req.ID, FileName: req.FileName})
+	}
+	return ms
+}
+
+func LoadRunManifest(path string) (*RunManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &RunManifest{path: path}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *RunManifest) Path() string {
+	return m.path
+}
+
+func (m *RunManifest) stagingDir() string {
+	return filepath.Dir(m.path) + "/" + m.StagingDir
+}
+
+// save writes to a temporary file first, so that a crash never leaves a truncated manifest behind
+func (m *RunManifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(m.path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(m.path+".tmp", m.path)
+}
+
+func (m *RunManifest) markDone(seq int, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.Splits {
+		if m.Splits[i].Seq == seq {
+			m.Splits[i].Done = true
+			m.Splits[i].Sha256 = checksum
+		}
+	}
+	return m.save()
+}
+
+// addDone records a split of a measured run once it is written, since such a run has no plan up front
+func (m *RunManifest) addDone(split zipSplit, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms := newManifestSplit(split)
+	ms.Done = true
+	ms.Sha256 = checksum
+	m.Splits = append(m.Splits, ms)
+	return m.save()
+}
+
+func (m *RunManifest) remove() error {
+	return os.Remove(m.path)
+}
+
+// restorePlan maps the manifest back to the requests parsed from the spreadsheet, which must be unchanged since the run
+func (m *RunManifest) restorePlan(requests []model.Request) ([]zipSplit, []OversizeFile, error) {
+	byRow := make(map[sheetRow]*model.Request)
+	for i := range requests {
+		byRow[sheetRow{requests[i].SheetName, requests[i].RowNumber}] = &requests[i]
+	}
+	lookup := func(mr ManifestRequest) (*model.Request, error) {
+		req, ok := byRow[sheetRow{mr.SheetName, mr.RowNumber}]
+		if !ok || req.FileName != mr.FileName || req.ID != mr.ID {
+			return nil, errors.New(rowLabel(mr.SheetName, mr.RowNumber) + " [" + mr.FileName + "] does not match the spreadsheet any more")
+		}
+		return req, nil
+	}
+	splits := make([]zipSplit, 0, len(m.Splits))
+	for _, ms := range m.Splits {
+		split := zipSplit{
+			seq:      ms.Seq,
+			count:    len(m.Splits),
+			fileName: ms.FileName,
+			oversize: ms.Oversize,
+			requests: make([]model.Request, 0, len(ms.Requests)),
+		}
+		for _, mr := range ms.Requests {
+			req, err := lookup(mr)
+			if err != nil {
+				return nil, nil, err
+			}
+			split.requests = append(split.requests, *req)
+		}
+		splits = append(splits, split)
+	}
+	rejects := make([]OversizeFile, 0, len(m.Rejects))
+	for _, mr := range m.Rejects {
+		req, err := lookup(mr)
+		if err != nil {
+			return nil, nil, err
+		}
+		rejects = append(rejects, OversizeFile{Request: req, Size: mr.Size})
+	}
+	return splits, rejects, nil
+}
+
+// verifiedDone tells whether a split marked as done is still in the staging dir unchanged
+func (m *RunManifest) verifiedDone(ms ManifestSplit, ext string) bool {
+	if !ms.Done {
+		return false
+	}
+	checksum, err := fileSha256(m.stagingDir() + "/" + ms.FileName + ext)
+	return err == nil && checksum == ms.Sha256
+}
+
+func fileSha256(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestRestorePlanKeysRowsBySheet(t *testing.T) {
+	requests := []model.Request{
+		{SheetName: "Sheet1", RowNumber: 2, ID: "1", FileName: "a.pdf"},
+		{SheetName: "Sheet2", RowNumber: 2, ID: "2", FileName: "b.pdf"},
+	}
+	splits := []zipSplit{
+		{seq: 1, fileName: "package-1", requests: requests[1:]},
+		{seq: 2, fileName: "package-2", requests: requests[:1]},
+	}
+	m := newRunManifest(t.TempDir()+"/.staging-1", ArchiveZip, "", splits, nil)
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRunManifest(m.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, _, err := loaded.restorePlan(requests)
+	if err != nil {
+		t.Fatalf("restorePlan failed: %v", err)
+	}
+	if restored[0].requests[0].FileName != "b.pdf" || restored[1].requests[0].FileName != "a.pdf" {
+		t.Errorf("rows of the same number in two sheets mixed up: %+v", restored)
+	}
+	_, _, err = loaded.restorePlan(requests[:1])
+	if err == nil || !strings.Contains(err.Error(), "sheet [Sheet2] row 2") {
+		t.Errorf("expect the sheet of a missing row in the error, got %v", err)
+	}
+}
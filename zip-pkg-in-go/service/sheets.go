@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"github.com/xuri/excelize/v2"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// several sheets of a workbook go either into one set of packages, or each into a sub dir of its own named by the sheet
+const (
+	SheetOutputCombined = "combined"
+	SheetOutputPerSheet = "per-sheet"
+)
+
+// SheetSet is what one run of package, validate or reconcile takes
+type SheetSet struct {
+	Label      string // the sheet name, or the names joined by + for a combined set
+	SheetNames []string
+	SubDir     string // of the output dir, empty for a combined set
+	Pkg        *model.Pkg
+	Headers    *[]ColHeader
+}
+
+func checkSheetOutput(output string) error {
+	if output == SheetOutputCombined || output == SheetOutputPerSheet {
+		return nil
+	}
+	return errors.New("unknown sheet output [" + output + "]")
+}
+
+// ResolveSheetNames takes a comma separated list of sheet names and globs, * for all sheets, and returns the matching
+// sheets in workbook order, or SheetName of the instruction for an empty spec. A csv, tsv or manifest file has no
+// sheets, and is taken as one, named by spec when it is a plain name, or by the file otherwise
+func (pi *ParseInstruction) ResolveSheetNames(file string, spec string) ([]string, error) {
+	patterns := make([]string, 0)
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	if !isWorkbook(file) {
+		if len(patterns) == 1 && !strings.ContainsAny(patterns[0], "*?[") {
+			return patterns, nil
+		}
+		return []string{strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))}, nil
+	}
+	if len(patterns) == 0 {
+		patterns = append(patterns, pi.SheetName)
+	}
+	f, err := excelize.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sheets := f.GetSheetList()
+	_ = f.Close()
+	matched := make(map[string]bool)
+	for _, p := range patterns {
+		found := false
+		for _, sheet := range sheets {
+			ok, err := path.Match(p, sheet)
+			if err != nil {
+				return nil, errors.New("bad sheet pattern [" + p + "]: " + err.Error())
+			}
+			if ok || p == sheet {
+				matched[sheet] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("no sheet matches [" + p + "] in [" + file + "]")
+		}
+	}
+	ret := make([]string, 0, len(matched))
+	for _, sheet := range sheets {
+		if matched[sheet] {
+			ret = append(ret, sheet)
+		}
+	}
+	return ret, nil
+}
+
+func isWorkbook(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv", ".tsv", ".tab":
+		return false
+	}
+	return !isRequestManifest(file)
+}
+
+// ParseSheetSets parses the sheets of spec into one combined set, or a set per sheet, as SheetOutput says.
+// Generated IDs go on counting across the sheets of a combined set, so that they stay unique
+func (pi *ParseInstruction) ParseSheetSets(file string, spec string) ([]SheetSet, error) {
+	if err := checkSheetOutput(pi.SheetOutput); err != nil {
+		return nil, err
+	}
+	sheetNames, err := pi.ResolveSheetNames(file, spec)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]SheetSet, 0)
+	for _, sheetName := range sheetNames {
+		sheetPi := *pi
+		sheetPi.SheetName = sheetName
+		if pi.SheetOutput == SheetOutputCombined && len(ret) == 1 {
+			sheetPi.idOffset = len(ret[0].Pkg.Requests)
+		}
+		pkg, headers, err := sheetPi.ParsePackageRequestsAndHeaders(file)
+		if err != nil {
+			return nil, errors.New("sheet [" + sheetName + "]: " + err.Error())
+		}
+		if pi.SheetOutput == SheetOutputPerSheet || len(ret) == 0 {
+			set := SheetSet{Label: sheetName, SheetNames: []string{sheetName}, Pkg: pkg, Headers: headers}
+			if pi.SheetOutput == SheetOutputPerSheet && len(sheetNames) > 1 {
+				set.SubDir = sheetName
+			}
+			ret = append(ret, set)
+			continue
+		}
+		set := &ret[0]
+		set.Label += "+" + sheetName
+		set.SheetNames = append(set.SheetNames, sheetName)
+		set.Pkg.Requests = append(set.Pkg.Requests, pkg.Requests...)
+		for _, header := range *headers {
+			if !hasHeader(*set.Headers, header.RawName) {
+				*set.Headers = append(*set.Headers, header)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// sheetRow keys a request by its row, since the sheets of a combined set each count rows from 1
+type sheetRow struct {
+	sheetName string
+	rowNumber int
+}
+
+// rowLabel names a row in a message, with its sheet when the request came from one
+func rowLabel(sheetName string, rowNumber int) string {
+	if sheetName == "" {
+		return "row " + strconv.Itoa(rowNumber)
+	}
+	return "sheet [" + sheetName + "] row " + strconv.Itoa(rowNumber)
+}
+
+func hasHeader(headers []ColHeader, rawName string) bool {
+	for _, header := range headers {
+		if header.RawName == rawName {
+			return true
+		}
+	}
+	return false
+}
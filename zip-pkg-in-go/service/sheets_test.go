@@ -0,0 +1,74 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveSheetNames(t *testing.T) {
+	xlsx := "../testdata/excel/pkg-test.xlsx"
+	tests := []struct {
+		file    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{xlsx, "*", []string{"Sheet1", "Sheet2"}, false},
+		{xlsx, "Sheet2, Sheet1", []string{"Sheet1", "Sheet2"}, false},
+		{xlsx, "Sheet?,Sheet1", []string{"Sheet1", "Sheet2"}, false},
+		{xlsx, "Sheet2", []string{"Sheet2"}, false},
+		{xlsx, "Sheet1,Nope", nil, true},
+		{xlsx, "", []string{"Sheet1"}, false},
+		{"../testdata/excel/pkg-test.csv", "Sheet1", []string{"Sheet1"}, false},
+		{"../testdata/excel/pkg-test.csv", "", []string{"pkg-test"}, false},
+		{"../testdata/excel/pkg-test.json", " ", []string{"pkg-test"}, false},
+		{"../testdata/excel/pkg-test.csv", "*", []string{"pkg-test"}, false},
+	}
+	for _, tt := range tests {
+		got, err := NewParseInstruction().ResolveSheetNames(tt.file, tt.spec)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v %q: got %v, %v, want %v", tt.file, tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSheetSets(t *testing.T) {
+	pi := NewParseInstruction()
+	sets, err := pi.ParseSheetSets("../testdata/excel/pkg-test.xlsx", "*")
+	if err != nil || len(sets) != 1 {
+		t.Fatalf("expect one combined set, got %v, %v", len(sets), err)
+	}
+	set := sets[0]
+	if set.Label != "Sheet1+Sheet2" || set.SubDir != "" || len(set.Pkg.Requests) != 5 {
+		t.Fatalf("unexpected combined set %v %q with %v requests", set.Label, set.SubDir, len(set.Pkg.Requests))
+	}
+	ids, sheets := make([]string, 0), make([]string, 0)
+	for _, req := range set.Pkg.Requests {
+		ids = append(ids, req.ID)
+		sheets = append(sheets, req.SheetName)
+	}
+	if want := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got IDs %v, want %v", ids, want)
+	}
+	if want := []string{"Sheet1", "Sheet1", "Sheet2", "Sheet2", "Sheet2"}; !reflect.DeepEqual(sheets, want) {
+		t.Errorf("got sheets %v, want %v", sheets, want)
+	}
+	// FileName, MimeType, DocName, FirstName, LastName and DOB are in both sheets, the group columns differ
+	if len(*set.Headers) != 13+7 {
+		t.Errorf("expect union of the headers, got %v", len(*set.Headers))
+	}
+
+	pi.SheetOutput = SheetOutputPerSheet
+	sets, err = pi.ParseSheetSets("../testdata/excel/pkg-test.xlsx", "Sheet*")
+	if err != nil || len(sets) != 2 {
+		t.Fatalf("expect a set per sheet, got %v, %v", len(sets), err)
+	}
+	if sets[1].Label != "Sheet2" || sets[1].SubDir != "Sheet2" || sets[1].Pkg.Requests[0].ID != "1" {
+		t.Errorf("unexpected set %+v", sets[1])
+	}
+
+	pi.SheetOutput = "nope"
+	if _, err = pi.ParseSheetSets("../testdata/excel/pkg-test.xlsx", "*"); err == nil {
+		t.Errorf("expect error for unknown sheet output")
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// SplitSidecar is written next to a split archive, so that transfer tooling can verify it before uploading it
+type SplitSidecar struct {
+	FileName   string         `json:"fileName"`
+	SplitSeq   int            `json:"splitSeq"`
+	SplitCount int            `json:"splitCount"`
+	Size       int64          `json:"size"`
+	Sha256     string         `json:"sha256"`
+	Entries    []SidecarEntry `json:"entries"`
+}
+
+type SidecarEntry struct {
+	Name           string `json:"name"`
+	RequestID      string `json:"requestId,omitempty"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressedSize,omitempty"` // not known for the entries of a tarball
+	Crc32          string `json:"crc32"`
+}
+
+func (zi *ZipInstruction) sidecarFileNames(fn string) []string {
+	return []string{fn + zi.archiveExt() + ".sha256", fn + ".manifest.json"}
+}
+
+// writeSidecars reads the written archive back for the entry sizes
+func (zi *ZipInstruction) writeSidecars(split zipSplit, outDir string, checksum string) error {
+	zipFile := outDir + "/" + split.fileName + zi.archiveExt()
+	names := zi.sidecarFileNames(split.fileName)
+	err := os.WriteFile(outDir+"/"+names[0], []byte(checksum+"  "+filepath.Base(zipFile)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	requestIds := make(map[string]string)
+	for _, req := range split.requests {
+		requestIds[req.FileName] = req.ID
+	}
+	entries, err := zi.readArchiveEntries(zipFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(zipFile)
+	if err != nil {
+		return err
+	}
+	sidecar := &SplitSidecar{
+		FileName:   filepath.Base(zipFile),
+		SplitSeq:   split.seq,
+		SplitCount: split.count,
+		Size:       info.Size(),
+		Sha256:     checksum,
+		Entries:    entries,
+	}
+	for i := range sidecar.Entries {
+		sidecar.Entries[i].RequestID = requestIds[sidecar.Entries[i].Name]
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outDir+"/"+names[1], data, 0644)
+}
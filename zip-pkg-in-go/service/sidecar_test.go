@@ -0,0 +1,34 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestZipWritesSidecars(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 30000, 30000})
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MaxSize = 70000
+	zi.Sidecar = true
+	if _, err := zi.Zip(&requests); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	checksum, _ := fileSha256(zi.DstDir + "/package-2.zip")
+	if got := string(readFile(t, zi.DstDir+"/package-2.zip.sha256")); got != checksum+"  package-2.zip\n" {
+		t.Errorf("got sha256 file %q", got)
+	}
+	sidecar := &SplitSidecar{}
+	if err := json.Unmarshal(readFile(t, zi.DstDir+"/package-2.manifest.json"), sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.SplitSeq != 2 || sidecar.SplitCount != 2 || sidecar.Sha256 != checksum || len(sidecar.Entries) != 2 {
+		t.Fatalf("unexpected sidecar %+v", sidecar)
+	}
+	if e := sidecar.Entries[0]; e.Name != "f-3.pdf" || e.RequestID != "3" || e.Size != 30000 || e.CompressedSize >= 30000 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := sidecar.Entries[1]; e.Name != zi.MetaXmlFileName || e.RequestID != "" || len(e.Crc32) != 8 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
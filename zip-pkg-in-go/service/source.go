@@ -0,0 +1,181 @@
+package service
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SourceResolver reads the source files of requests by their paths relative to the root of the source,
+// with slashes as separators
+type SourceResolver interface {
+	// Stat returns the size of a source file, or an error wrapping fs.ErrNotExist when there is none
+	Stat(path string) (int64, error)
+	Open(path string) (io.ReadCloser, error)
+	// List returns the paths of all source files, for the recursive source lookup
+	List() ([]string, error)
+}
+
+// localPather is implemented by sources on the local file system, so that the unzip dir can hard link them
+type localPather interface {
+	localPath(path string) (string, bool)
+}
+
+// LocalSource reads from a local directory, the default source of ZipInstruction
+type LocalSource struct {
+	Dir string
+}
+
+func (s *LocalSource) Stat(path string) (int64, error) {
+	info, err := os.Stat(s.Dir + "/" + path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *LocalSource) Open(path string) (io.ReadCloser, error) {
+	return os.Open(s.Dir + "/" + path)
+}
+
+func (s *LocalSource) List() ([]string, error) {
+	ret := make([]string, 0)
+	err := filepath.WalkDir(s.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, p)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, filepath.ToSlash(rel))
+		return nil
+	})
+	return ret, err
+}
+
+func (s *LocalSource) localPath(path string) (string, bool) {
+	return s.Dir + "/" + path, true
+}
+
+// MultiDirSource reads from a list of local directories, a file in an earlier directory hides the one of the same path in later ones
+type MultiDirSource struct {
+	Dirs []LocalSource
+}
+
+func NewMultiDirSource(dirs ...string) *MultiDirSource {
+	s := &MultiDirSource{Dirs: make([]LocalSource, 0, len(dirs))}
+	for _, dir := range dirs {
+		s.Dirs = append(s.Dirs, LocalSource{Dir: dir})
+	}
+	return s
+}
+
+func (s *MultiDirSource) find(path string) (*LocalSource, int64, error) {
+	for i := range s.Dirs {
+		size, err := s.Dirs[i].Stat(path)
+		if err == nil {
+			return &s.Dirs[i], size, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, err
+		}
+	}
+	return nil, 0, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+func (s *MultiDirSource) Stat(path string) (int64, error) {
+	_, size, err := s.find(path)
+	return size, err
+}
+
+func (s *MultiDirSource) Open(path string) (io.ReadCloser, error) {
+	dir, _, err := s.find(path)
+	if err != nil {
+		return nil, err
+	}
+	return dir.Open(path)
+}
+
+func (s *MultiDirSource) List() ([]string, error) {
+	seen := make(map[string]bool)
+	ret := make([]string, 0)
+	for i := range s.Dirs {
+		paths, err := s.Dirs[i].List()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if !seen[p] {
+				seen[p] = true
+				ret = append(ret, p)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (s *MultiDirSource) localPath(path string) (string, bool) {
+	dir, _, err := s.find(path)
+	if err != nil {
+		return "", false
+	}
+	return dir.localPath(path)
+}
+
+// ZipArchiveSource reads the entries of an existing zip, e.g. a delivery of documents which is repackaged
+type ZipArchiveSource struct {
+	r     *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func NewZipArchiveSource(zipFile string) (*ZipArchiveSource, error) {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &ZipArchiveSource{r: r, files: make(map[string]*zip.File)}
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, "/") {
+			s.files[strings.TrimPrefix(f.Name, "./")] = f
+		}
+	}
+	return s, nil
+}
+
+func (s *ZipArchiveSource) Stat(path string) (int64, error) {
+	f, ok := s.files[path]
+	if !ok {
+		return 0, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	return int64(f.UncompressedSize64), nil
+}
+
+func (s *ZipArchiveSource) Open(path string) (io.ReadCloser, error) {
+	f, ok := s.files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return f.Open()
+}
+
+func (s *ZipArchiveSource) List() ([]string, error) {
+	ret := make([]string, 0, len(s.files))
+	for name := range s.files {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (s *ZipArchiveSource) Close() error {
+	return s.r.Close()
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipFromSources(t *testing.T) {
+	files := map[string]string{"a.pdf": "aaa", "sub/b.pdf": "bbbb"}
+	newRequests := func() []model.Request {
+		return []model.Request{
+			{RowNumber: 1, ID: "1", FileName: "a.pdf"},
+			{RowNumber: 2, ID: "2", FileName: "b.pdf"},
+		}
+	}
+
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	writeFiles(t, dir1, map[string]string{"a.pdf": "aaa"})
+	writeFiles(t, dir2, map[string]string{"a.pdf": "hidden", "sub/b.pdf": "bbbb"})
+
+	zipFile := t.TempDir() + "/delivery.zip"
+	f, err := os.Create(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		_, _ = w.Write([]byte(content))
+	}
+	_ = zw.Close()
+	_ = f.Close()
+	zipSource, err := NewZipArchiveSource(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = zipSource.Close()
+	}()
+
+	server := httptest.NewServer(newFakeS3("docs", "in/", files))
+	defer server.Close()
+	s3Source, err := NewS3Source(S3Config{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Region:   "us-east-1",
+		Bucket:   "docs",
+		Prefix:   "/in/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source SourceResolver
+	}{
+		{"dirs", NewMultiDirSource(dir1, dir2)},
+		{"zip", zipSource},
+		{"s3", s3Source},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := newRequests()
+			zi := newTestZipInstruction(t, "does-not-matter")
+			zi.Unzip = true
+			zi.Source = tt.source
+			zi.SourceLookup = SourceLookupRecursive
+			zi.UnzipMode = UnzipHardlink
+			zi.MeasureCompressed = true
+			zi.Verify = true
+			if _, err := zi.Zip(&requests); err != nil {
+				t.Fatalf("Zip failed: %v", err)
+			}
+			for name, content := range map[string]string{"a.pdf": "aaa", "sub/b.pdf": "bbbb"} {
+				if got := string(readFile(t, zi.DstDir+"/package-1.d/"+name)); got != content {
+					t.Errorf("%v has %q, want %q", name, got, content)
+				}
+			}
+		})
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if idx := strings.LastIndex(name, "/"); idx != -1 {
+			if err := os.MkdirAll(dir+"/"+name[:idx], 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newFakeS3 serves head and get of objects, and list objects v2, with path style requests
+func newFakeS3(bucket string, prefix string, files map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+bucket+"/" && r.URL.Query().Get("list-type") == "2" {
+			var sb strings.Builder
+			sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>` + bucket + `</Name><IsTruncated>false</IsTruncated>`)
+			for name, content := range files {
+				sb.WriteString(fmt.Sprintf("<Contents><Key>%v</Key><Size>%v</Size></Contents>", prefix+name, len(content)))
+			}
+			sb.WriteString("</ListBucketResult>")
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(sb.String()))
+			return
+		}
+		content, ok := files[strings.TrimPrefix(r.URL.Path, "/"+bucket+"/"+prefix)]
+		if !ok || !strings.HasPrefix(r.URL.Path, "/"+bucket+"/"+prefix) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method != http.MethodHead {
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`))
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"`+strconv.Itoa(len(content))+`"`)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write([]byte(content))
+		}
+	})
+}
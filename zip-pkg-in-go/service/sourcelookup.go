@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// how the source file of a FileName is found. FileName can have a relative subpath in both modes,
+// recursive looks for the base name anywhere under the source dir, unless the subpath exists as it is
+const (
+	SourceLookupPath      = "path"
+	SourceLookupRecursive = "recursive"
+)
+
+type SourceLookupError struct {
+	Issues []ValidationIssue
+}
+
+func (e *SourceLookupError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		msgs = append(msgs, issue.Label()+" "+issue.Message)
+	}
+	return strconv.Itoa(len(e.Issues)) + " source file(s) not resolved: " + strings.Join(msgs, "; ")
+}
+
+func checkSourceLookup(mode string) error {
+	if mode == SourceLookupPath || mode == SourceLookupRecursive {
+		return nil
+	}
+	return errors.New("unknown source lookup [" + mode + "]")
+}
+
+// resolveSources checks every FileName, then sets the source path of every request, and its FileName to the zip entry name,
+// which keeps the subpath only when PreserveSubpath is on. Requests resolved before are kept as they are
+func (zi *ZipInstruction) resolveSources(requests []model.Request) error {
+	if err := checkFileNames(requests); err != nil {
+		return err
+	}
+	var byBaseName map[string][]string
+	issues := make([]ValidationIssue, 0)
+	for i := range requests {
+		req := &requests[i]
+		if req.SourcePath != "" {
+			continue
+		}
+		sourcePath := path.Clean(req.FileName)
+		if zi.SourceLookup == SourceLookupRecursive {
+			if _, err := zi.source().Stat(sourcePath); err != nil {
+				if byBaseName == nil {
+					var err2 error
+					if byBaseName, err2 = indexByBaseName(zi.source()); err2 != nil {
+						return err2
+					}
+				}
+				found := byBaseName[path.Base(sourcePath)]
+				if len(found) > 1 {
+					issues = append(issues, rowIssue(*req, IssueAmbiguousFileName, true, "is ambiguous: "+strings.Join(found, ", ")))
+					continue
+				}
+				if len(found) == 1 {
+					sourcePath = found[0]
+				}
+			}
+		}
+		req.SourcePath = sourcePath
+		req.FileName = sourcePath
+		if !zi.PreserveSubpath {
+			req.FileName = path.Base(sourcePath)
+		}
+	}
+	if len(issues) > 0 {
+		return &SourceLookupError{Issues: issues}
+	}
+	if zi.SourceLookup == SourceLookupRecursive {
+		// a path found by the lookup comes from the source, e.g. an entry of a source zip, so it is checked as well
+		return checkFileNames(requests)
+	}
+	return nil
+}
+
+// indexByBaseName lists the source files by their base names
+func indexByBaseName(source SourceResolver) (map[string][]string, error) {
+	paths, err := source.List()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]string)
+	for _, p := range paths {
+		ret[path.Base(p)] = append(ret[path.Base(p)], p)
+	}
+	for _, found := range ret {
+		sort.Strings(found)
+	}
+	return ret, nil
+}
+
+// source is the source resolver, or the source dir when there is none
+func (zi *ZipInstruction) source() SourceResolver {
+	if zi.Source == nil {
+		return &LocalSource{Dir: zi.SrcDir}
+	}
+	return zi.Source
+}
+
+// sourcePath is the path of the source of a request relative to the source
+func sourcePath(req *model.Request) string {
+	if req.SourcePath == "" {
+		return req.FileName
+	}
+	return req.SourcePath
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipSourceLookup(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, fn := range []string{"2024/01/a.pdf", "2025/b.pdf", "x/dup.pdf", "y/dup.pdf"} {
+		if err := os.MkdirAll(filepath.Dir(srcDir+"/"+fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(srcDir+"/"+fn, []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name            string
+		lookup          string
+		preserveSubpath bool
+		fileNames       []string
+		wantEntries     []string
+	}{
+		{"path", SourceLookupPath, true, []string{"2024/01/a.pdf", "./2025/b.pdf"}, []string{"2024/01/a.pdf", "2025/b.pdf"}},
+		{"path-flatten", SourceLookupPath, false, []string{"2024/01/a.pdf", "2025/b.pdf"}, []string{"a.pdf", "b.pdf"}},
+		{"recursive", SourceLookupRecursive, true, []string{"a.pdf", "x/dup.pdf"}, []string{"2024/01/a.pdf", "x/dup.pdf"}},
+		{"recursive-flatten", SourceLookupRecursive, false, []string{"a.pdf", "b.pdf"}, []string{"a.pdf", "b.pdf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make([]model.Request, 0)
+			for i, fn := range tt.fileNames {
+				requests = append(requests, model.Request{RowNumber: i + 1, ID: fn, FileName: fn})
+			}
+			zi := newTestZipInstruction(t, srcDir)
+			zi.Unzip = true
+			zi.SourceLookup = tt.lookup
+			zi.PreserveSubpath = tt.preserveSubpath
+			zi.Verify = true
+			if _, err := zi.Zip(&requests); err != nil {
+				t.Fatalf("Zip failed: %v", err)
+			}
+			entries := readZipEntries(t, zi.DstDir, []string{"package-1"})[0]
+			for i, want := range tt.wantEntries {
+				if entries[i] != want || requests[i].FileName != want {
+					t.Errorf("got entry %v and FileName %v, want %v", entries[i], requests[i].FileName, want)
+				}
+				if _, err := os.Stat(zi.DstDir + "/package-1.d/" + want); err != nil {
+					t.Errorf("missing %v in unzip dir: %v", want, err)
+				}
+			}
+		})
+	}
+	requests := []model.Request{{RowNumber: 7, ID: "1", FileName: "dup.pdf"}}
+	zi := newTestZipInstruction(t, srcDir)
+	zi.SourceLookup = SourceLookupRecursive
+	_, err := zi.Zip(&requests)
+	var lookupErr *SourceLookupError
+	if !errors.As(err, &lookupErr) || len(lookupErr.Issues) != 1 || lookupErr.Issues[0].RowNumber != 7 ||
+		!strings.Contains(lookupErr.Error(), "row 7") {
+		t.Errorf("expect ambiguity of row 7, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// S3Source reads objects under a prefix of a bucket in an S3 compatible store, e.g. MinIO
+type S3Source struct {
+	Client *minio.Client
+	Bucket string
+	Prefix string
+}
+
+type S3Config struct {
+	Endpoint  string // host:port without scheme
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Bucket    string
+	Prefix    string
+}
+
+// NewS3Source uses path style requests, which is what MinIO and most S3 compatible stores expect
+func NewS3Source(cfg S3Config) (*S3Source, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3Source{Client: client, Bucket: cfg.Bucket, Prefix: prefix}, nil
+}
+
+func (s *S3Source) Stat(path string) (int64, error) {
+	info, err := s.Client.StatObject(context.Background(), s.Bucket, s.Prefix+path, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, s.wrapError("stat", path, err)
+	}
+	return info.Size, nil
+}
+
+func (s *S3Source) Open(path string) (io.ReadCloser, error) {
+	obj, err := s.Client.GetObject(context.Background(), s.Bucket, s.Prefix+path, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.wrapError("open", path, err)
+	}
+	return obj, nil
+}
+
+func (s *S3Source) List() ([]string, error) {
+	ret := make([]string, 0)
+	for obj := range s.Client.ListObjects(context.Background(), s.Bucket, minio.ListObjectsOptions{Prefix: s.Prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if !strings.HasSuffix(obj.Key, "/") {
+			ret = append(ret, strings.TrimPrefix(obj.Key, s.Prefix))
+		}
+	}
+	return ret, nil
+}
+
+func (s *S3Source) wrapError(op string, path string, err error) error {
+	if resp := minio.ToErrorResponse(err); resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey" {
+		return &fs.PathError{Op: op, Path: "s3://" + s.Bucket + "/" + s.Prefix + path, Err: fs.ErrNotExist}
+	}
+	return fmt.Errorf("s3://%v/%v: %w", s.Bucket, s.Prefix+path, err)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"sort"
+)
+
+// SplitStrategy groups files into splits. It gets the size of every file in spreadsheet order,
+// and returns the file indexes of each split
+type SplitStrategy interface {
+	Split(sizes []int64, maxSize int64) [][]int
+}
+
+const (
+	SplitSequential         = "sequential"
+	SplitFirstFitDecreasing = "first-fit-decreasing"
+	SplitBestFit            = "best-fit"
+)
+
+var splitStrategies = map[string]SplitStrategy{
+	SplitSequential:         sequentialSplit{},
+	SplitFirstFitDecreasing: firstFitDecreasingSplit{},
+	SplitBestFit:            bestFitSplit{},
+}
+
+func RegisterSplitStrategy(name string, strategy SplitStrategy) {
+	splitStrategies[name] = strategy
+}
+
+func LookupSplitStrategy(name string) (SplitStrategy, error) {
+	if name == "" {
+		name = SplitSequential
+	}
+	strategy, ok := splitStrategies[name]
+	if !ok {
+		return nil, errors.New("unknown split strategy [" + name + "]")
+	}
+	return strategy, nil
+}
+
+// sequentialSplit keeps spreadsheet order and cuts a new split once the running size exceeds max size
+type sequentialSplit struct{}
+
+func (s sequentialSplit) Split(sizes []int64, maxSize int64) [][]int {
+	ret := make([][]int, 0)
+	group := make([]int, 0)
+	size := int64(0)
+	for i, fileSize := range sizes {
+		size += fileSize
+		if size > maxSize && len(group) > 0 {
+			ret = append(ret, group)
+			group = make([]int, 0)
+			size = fileSize
+		}
+		group = append(group, i)
+	}
+	if len(group) > 0 {
+		ret = append(ret, group)
+	}
+	return ret
+}
+
+// firstFitDecreasingSplit puts the biggest files first, each one into the first split that still has room
+type firstFitDecreasingSplit struct{}
+
+func (s firstFitDecreasingSplit) Split(sizes []int64, maxSize int64) [][]int {
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return sizes[order[a]] > sizes[order[b]]
+	})
+	bins := make([]*splitBin, 0)
+	for _, i := range order {
+		var target *splitBin = nil
+		for _, bin := range bins {
+			if bin.size+sizes[i] <= maxSize {
+				target = bin
+				break
+			}
+		}
+		bins = addToBin(bins, target, i, sizes[i])
+	}
+	return binsInSpreadsheetOrder(bins)
+}
+
+// bestFitSplit keeps spreadsheet order, each file goes into the split which has the least room left after adding it
+type bestFitSplit struct{}
+
+func (s bestFitSplit) Split(sizes []int64, maxSize int64) [][]int {
+	bins := make([]*splitBin, 0)
+	for i, fileSize := range sizes {
+		var target *splitBin = nil
+		for _, bin := range bins {
+			if bin.size+fileSize <= maxSize && (target == nil || bin.size > target.size) {
+				target = bin
+			}
+		}
+		bins = addToBin(bins, target, i, fileSize)
+	}
+	return binsInSpreadsheetOrder(bins)
+}
+
+type splitBin struct {
+	size    int64
+	indexes []int
+}
+
+func addToBin(bins []*splitBin, bin *splitBin, index int, size int64) []*splitBin {
+	if bin == nil {
+		bin = &splitBin{}
+		bins = append(bins, bin)
+	}
+	bin.size += size
+	bin.indexes = append(bin.indexes, index)
+	return bins
+}
+
+// binsInSpreadsheetOrder sorts files inside each split, and splits by their first file,
+// so that packages still follow the spreadsheet as much as possible
+func binsInSpreadsheetOrder(bins []*splitBin) [][]int {
+	ret := make([][]int, 0, len(bins))
+	for _, bin := range bins {
+		sort.Ints(bin.indexes)
+		ret = append(ret, bin.indexes)
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a][0] < ret[b][0]
+	})
+	return ret
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		sizes    []int64
+		want     [][]int
+	}{
+		{SplitSequential, []int64{600, 300, 500, 200, 400}, [][]int{{0, 1}, {2, 3}, {4}}},
+		{SplitFirstFitDecreasing, []int64{600, 300, 500, 200, 400}, [][]int{{0, 4}, {1, 2, 3}}},
+		{SplitBestFit, []int64{600, 300, 500, 200, 400}, [][]int{{0, 1}, {2, 3}, {4}}},
+		{SplitSequential, []int64{500, 600, 400, 500}, [][]int{{0}, {1, 2}, {3}}},
+		{SplitFirstFitDecreasing, []int64{500, 600, 400, 500}, [][]int{{0, 3}, {1, 2}}},
+		{SplitBestFit, []int64{500, 600, 400, 500}, [][]int{{0, 3}, {1, 2}}},
+		{SplitSequential, []int64{1200, 100, 300}, [][]int{{0}, {1, 2}}},
+		{SplitSequential, []int64{}, [][]int{}},
+		{SplitFirstFitDecreasing, []int64{}, [][]int{}},
+		{SplitBestFit, []int64{}, [][]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			strategy, err := LookupSplitStrategy(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strategy.Split(tt.sizes, 1000); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split(%v) = %v, want %v", tt.sizes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupUnknownSplitStrategy(t *testing.T) {
+	if _, err := LookupSplitStrategy("worst-fit"); err == nil {
+		t.Errorf("expect error for unknown split strategy")
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// newStagingDir creates a hidden directory inside the output dir, so that renaming out of it stays in one file system
+func (zi *ZipInstruction) newStagingDir() (string, error) {
+	err := os.MkdirAll(zi.DstDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(zi.DstDir, ".staging-")
+}
+
+// commitStagingDir moves everything out of the staging dir into the output dir. It checks all the targets
+// first, so that nothing is moved when any of them exists already
+func commitStagingDir(stagingDir string, dstDir string) error {
+	entries, err := os.ReadDir(stagingDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err2 := os.Lstat(dstDir + "/" + entry.Name()); err2 == nil {
+			return errors.New("[" + dstDir + "/" + entry.Name() + "] exists already")
+		}
+	}
+	for _, entry := range entries {
+		err = os.Rename(stagingDir+"/"+entry.Name(), dstDir+"/"+entry.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(stagingDir)
+}
+
+func (zi *ZipInstruction) abortStagingDir(stagingDir string) {
+	if zi.KeepPartial {
+		fmt.Println("Keep partial output in: ", stagingDir)
+		return
+	}
+	if err := os.RemoveAll(stagingDir); err != nil {
+		fmt.Printf("Error removing staging dir %v: %v\n", stagingDir, err)
+	}
+}
+
+// keepDoneSplits leaves the splits done so far in the staging dir of a failed run, for the run manifest to resume from.
+// The leftovers of the other splits are removed as well, unless KeepPartial keeps them for debugging
+func (zi *ZipInstruction) keepDoneSplits(manifest *RunManifest, splits []zipSplit) {
+	stagingDir := manifest.stagingDir()
+	if zi.KeepPartial {
+		fmt.Println("Keep partial output in: ", stagingDir)
+		return
+	}
+	for i, split := range splits {
+		if !manifest.Splits[i].Done {
+			zi.removeSplitOutput(stagingDir, split)
+		}
+	}
+	fmt.Println("Keep done splits in: ", stagingDir)
+}
+
+func (zi *ZipInstruction) removeSplitOutput(stagingDir string, split zipSplit) {
+	_ = os.RemoveAll(stagingDir + "/" + split.fileName + zi.archiveExt())
+	_ = os.RemoveAll(stagingDir + "/" + split.fileName + ".d")
+	for _, sidecar := range zi.sidecarFileNames(split.fileName) {
+		_ = os.RemoveAll(stagingDir + "/" + sidecar)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitNameVars are the values of a split for the placeholders of TargetFileNamePattern
+type splitNameVars struct {
+	tm           time.Time
+	seq          int
+	count        int
+	requestCount int
+}
+
+const dateLetters = "yMdHmsS"
+
+func (zi *ZipInstruction) checkTargetFileNamePattern() error {
+	_, err := zi.resolveTargetFileName(splitNameVars{tm: time.Now(), seq: 1, count: 1, requestCount: 1})
+	return err
+}
+
+// resolveTargetFileName fills in the placeholders of TargetFileNamePattern, the result must be a plain file name
+func (zi *ZipInstruction) resolveTargetFileName(vars splitNameVars) (string, error) {
+	pattern := zi.TargetFileNamePattern
+	var sb strings.Builder
+	for {
+		start := strings.Index(pattern, "${")
+		if start == -1 {
+			sb.WriteString(pattern)
+			break
+		}
+		end := strings.Index(pattern[start:], "}")
+		if end == -1 {
+			return "", errors.New("unclosed placeholder in target file name pattern [" + zi.TargetFileNamePattern + "]")
+		}
+		sb.WriteString(pattern[:start])
+		value, err := zi.resolvePlaceholder(pattern[start+2:start+end], vars)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(value)
+		pattern = pattern[start+end+1:]
+	}
+	if sb.Len() == 0 || strings.ContainsAny(sb.String(), "/\\") {
+		return "", errors.New("target file name [" + sb.String() + "] must be a plain file name")
+	}
+	return sb.String(), nil
+}
+
+func (zi *ZipInstruction) resolvePlaceholder(placeholder string, vars splitNameVars) (string, error) {
+	name, arg, hasArg := strings.Cut(placeholder, ":")
+	switch name {
+	case "date":
+		return formatDate(vars.tm, arg)
+	case "splitSeq":
+		return padNumber(vars.seq, arg)
+	case "splitCount":
+		return padNumber(vars.count, arg)
+	case "requestCount":
+		return padNumber(vars.requestCount, arg)
+	}
+	if hasArg {
+		return "", errors.New("placeholder ${" + placeholder + "} takes no argument")
+	}
+	switch name {
+	case "sourceId":
+		return zi.SourceID, nil
+	case "sheetName":
+		return zi.SheetName, nil
+	case "excelName":
+		return zi.ExcelName, nil
+	case "runId":
+		return zi.RunID, nil
+	}
+	if name != "" && strings.Trim(name, dateLetters) == "" {
+		return formatDate(vars.tm, name)
+	}
+	return "", errors.New("unknown placeholder ${" + placeholder + "}")
+}
+
+func padNumber(n int, width string) (string, error) {
+	if width == "" {
+		return strconv.Itoa(n), nil
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil || w < 1 {
+		return "", errors.New("width [" + width + "] of a number placeholder must be a positive number")
+	}
+	return fmt.Sprintf("%0*d", w, n), nil
+}
+
+// formatDate takes the letters of a Java date pattern, since the original ${yyMMddHHmmssSSS} placeholder is one
+func formatDate(tm time.Time, layout string) (string, error) {
+	if layout == "" {
+		return "", errors.New("date placeholder needs a layout, e.g. ${date:yyyyMMdd}")
+	}
+	var sb strings.Builder
+	for i := 0; i < len(layout); {
+		c := layout[i]
+		n := 1
+		for i+n < len(layout) && layout[i+n] == c {
+			n++
+		}
+		i += n
+		if !strings.ContainsRune(dateLetters, rune(c)) {
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+				return "", errors.New("unknown date pattern letter [" + string(c) + "] in [" + layout + "]")
+			}
+			sb.WriteString(strings.Repeat(string(c), n))
+			continue
+		}
+		switch {
+		case c == 'y' && n == 2:
+			sb.WriteString(fmt.Sprintf("%02d", tm.Year()%100))
+		case c == 'y' && n == 4:
+			sb.WriteString(fmt.Sprintf("%04d", tm.Year()))
+		case c == 'M' && n == 2:
+			sb.WriteString(fmt.Sprintf("%02d", tm.Month()))
+		case c == 'd' && n == 2:
+			sb.WriteString(fmt.Sprintf("%02d", tm.Day()))
+		case c == 'H' && n == 2:
+			sb.WriteString(fmt.Sprintf("%02d", tm.Hour()))
+		case c == 'm' && n == 2:
+			sb.WriteString(fmt.Sprintf("%02d", tm.Minute()))
+		case c == 's' && n == 2:
+			sb.WriteString(fmt.Sprintf("%02d", tm.Second()))
+		case c == 'S' && n == 3:
+			sb.WriteString(fmt.Sprintf("%03d", tm.Nanosecond()/1000000))
+		default:
+			return "", errors.New("unsupported date pattern [" + strings.Repeat(string(c), n) + "] in [" + layout + "]")
+		}
+	}
+	return sb.String(), nil
+}
+
+// uniqueTargetFileNames suffixes a name with -2, -3 and so on, when it is taken by an earlier split of the run,
+// or by the output of an earlier run, e.g. one started in the same millisecond
+func (zi *ZipInstruction) uniqueTargetFileNames(names []string) []string {
+	taken := make(map[string]bool)
+	exists := func(name string) bool {
+		names := []string{name + zi.archiveExt(), name + ".d"}
+		if zi.Sidecar {
+			names = append(names, zi.sidecarFileNames(name)...)
+		}
+		for _, fn := range names {
+			if _, err := os.Lstat(zi.DstDir + "/" + fn); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	ret := make([]string, 0, len(names))
+	for _, name := range names {
+		unique := name
+		for n := 2; taken[unique] || exists(unique); n++ {
+			unique = name + "-" + strconv.Itoa(n)
+		}
+		taken[unique] = true
+		ret = append(ret, unique)
+	}
+	return ret
+}
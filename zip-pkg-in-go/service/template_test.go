@@ -0,0 +1,69 @@
+package service
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveTargetFileName(t *testing.T) {
+	tm := time.Date(2024, 3, 7, 9, 5, 2, 45000000, time.UTC)
+	vars := splitNameVars{tm: tm, seq: 7, count: 12, requestCount: 250}
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{"package-${yyMMddHHmmssSSS}-${splitSeq}", "package-240307090502045-7", false},
+		{"${date:yyyy-MM-dd_HHmmss}-${splitSeq:03}-of-${splitCount:03}", "2024-03-07_090502-007-of-012", false},
+		{"${sourceId}-${excelName}-${sheetName}-${requestCount:5}", "0086-metadata-Sheet1-00250", false},
+		{"${runId}", "run-1", false},
+		{"${date:yyyyMMddQ}", "", true},
+		{"${date}", "", true},
+		{"${splitSeq:x}", "", true},
+		{"${sourceId:3}", "", true},
+		{"${unknown}", "", true},
+		{"package-${splitSeq", "", true},
+		{"a/${splitSeq}", "", true},
+	}
+	zi := NewZipInstruction()
+	zi.ExcelName = "metadata"
+	zi.SheetName = "Sheet1"
+	zi.RunID = "run-1"
+	for _, tt := range tests {
+		zi.TargetFileNamePattern = tt.pattern
+		got, err := zi.resolveTargetFileName(vars)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%v: got %q, %v, want %q", tt.pattern, got, err, tt.want)
+		}
+	}
+}
+
+func TestZipTargetFileNamesNeverCollide(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{100, 100, 100})
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MaxSize = 1500
+	zi.TargetFileNamePattern = "package-${sheetName}"
+	zi.SheetName = "Sheet1"
+	if err := os.WriteFile(zi.DstDir+"/package-Sheet1.zip", []byte("earlier run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := zi.Zip(&requests)
+	if err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	// the first split gets -2, since package-Sheet1 is taken by the earlier run, and the next ones count on
+	want := [][]string{{"f-1.pdf", zi.MetaXmlFileName}, {"f-2.pdf", zi.MetaXmlFileName}, {"f-3.pdf", zi.MetaXmlFileName}}
+	got := readZipEntries(t, zi.DstDir, []string{"package-Sheet1-2", "package-Sheet1-3", "package-Sheet1-4"})
+	if summary.SplitCount != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v splits with entries %v, want %v", summary.SplitCount, got, want)
+	}
+	if string(readFile(t, zi.DstDir+"/package-Sheet1.zip")) != "earlier run" {
+		t.Errorf("expect the archive of the earlier run untouched")
+	}
+	if zi.RunID == "" {
+		t.Errorf("expect a run id")
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"zip-pkg-in-go/model"
+)
+
+// how the unzip dir next to a split is filled. Hard links share the source files, so they must not be changed
+// afterwards, and fall back to copy when the source is on another file system, or not a local file at all. Extract reads the written archive back,
+// so that the unzip dir holds exactly what the archive does
+const (
+	UnzipCopy     = "copy"
+	UnzipHardlink = "hardlink"
+	UnzipExtract  = "extract"
+)
+
+func checkUnzipMode(mode string) error {
+	if mode == UnzipCopy || mode == UnzipHardlink || mode == UnzipExtract {
+		return nil
+	}
+	return errors.New("unknown unzip mode [" + mode + "]")
+}
+
+// doMirrorSourceFile creates the subdirs of the entry name in the unzip dir, since FileName can have a subpath
+func (zi *ZipInstruction) doMirrorSourceFile(req model.Request, dstDir string) error {
+	if err := os.MkdirAll(filepath.Dir(dstDir+"/"+req.FileName), 0755); err != nil {
+		return err
+	}
+	if lp, ok := zi.source().(localPather); ok && zi.UnzipMode == UnzipHardlink {
+		if src, ok := lp.localPath(sourcePath(&req)); ok {
+			if err := os.Link(src, dstDir+"/"+req.FileName); err == nil {
+				fmt.Println("linked: ", req.FileName)
+				return nil
+			}
+			// e.g. across file systems
+		}
+	}
+	return zi.doCopySourceFile(req, dstDir)
+}
+
+// extractArchive fills the unzip dir from the archive just written
+func (zi *ZipInstruction) extractArchive(archiveFile string, dstDir string) error {
+	err := os.Mkdir(dstDir, 0755)
+	if err != nil {
+		return err
+	}
+	return zi.walkArchive(archiveFile, func(name string, r io.Reader) error {
+		target := filepath.Join(dstDir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		w, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(w, r); err != nil {
+			_ = w.Close()
+			return err
+		}
+		if err = w.Close(); err != nil {
+			return err
+		}
+		fmt.Println("extracted: ", name)
+		return nil
+	})
+}
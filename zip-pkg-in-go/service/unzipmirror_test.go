@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/xml"
+	"os"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipUnzipModes(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 20000})
+	tests := []struct {
+		mode       string
+		format     string
+		encryption string
+	}{
+		{UnzipCopy, ArchiveZip, EncryptionNone},
+		{UnzipHardlink, ArchiveZip, EncryptionNone},
+		{UnzipExtract, ArchiveZip, EncryptionNone},
+		{UnzipExtract, ArchiveTarGz, EncryptionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"-"+tt.format, func(t *testing.T) {
+			zi := newTestZipInstruction(t, srcDir)
+			zi.Unzip = true
+			zi.UnzipMode = tt.mode
+			zi.ArchiveFormat = tt.format
+			zi.Encryption = tt.encryption
+			zi.EncryptionPassword = "s3cret"
+			if _, err := zi.Zip(&requests); err != nil {
+				t.Fatalf("Zip failed: %v", err)
+			}
+			unzipD := zi.DstDir + "/package-1.d"
+			for _, req := range requests {
+				if string(readFile(t, unzipD+"/"+req.FileName)) != string(readFile(t, srcDir+"/"+req.FileName)) {
+					t.Errorf("%v differs from source", req.FileName)
+				}
+				srcInfo, _ := os.Stat(srcDir + "/" + req.FileName)
+				dstInfo, _ := os.Stat(unzipD + "/" + req.FileName)
+				if linked := os.SameFile(srcInfo, dstInfo); linked != (tt.mode == UnzipHardlink) {
+					t.Errorf("%v linked %v in mode %v", req.FileName, linked, tt.mode)
+				}
+			}
+			pkg := &model.Pkg{}
+			if err := xml.Unmarshal(readFile(t, unzipD+"/"+zi.MetaXmlFileName), pkg); err != nil || len(pkg.Requests) != 2 {
+				t.Errorf("expect 2 requests in meta xml, got %v: %v", len(pkg.Requests), err)
+			}
+		})
+	}
+	zi := NewZipInstruction()
+	zi.UnzipMode = "symlink"
+	if err := zi.checkOptions(); err == nil {
+		t.Errorf("expect error for unknown unzip mode")
+	}
+}
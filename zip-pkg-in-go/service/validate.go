@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+const (
+	IssueMissingFile       = "MissingFile"
+	IssueDuplicateFileName = "DuplicateFileName"
+	IssueDuplicateRefID    = "DuplicateRefID"
+	IssueEmptyFileName     = "EmptyFileName"
+	IssueMimeTypeMismatch  = "MimeTypeMismatch"
+	IssueUnreferencedFile  = "UnreferencedFile"
+	IssueUnsafeFileName    = "UnsafeFileName"
+	IssueAmbiguousFileName = "AmbiguousFileName"
+)
+
+// ValidateInstruction takes the source options of package, so that it finds the files package would zip
+type ValidateInstruction struct {
+	SrcDir          string
+	Source          SourceResolver // SrcDir is used when there is none
+	SourceLookup    string
+	PreserveSubpath bool
+	DuplicatePolicy string
+}
+
+type ValidationIssue struct {
+	SheetName string // of the row, empty for a csv, tsv or manifest file
+	RowNumber int    // 0 when the issue is not about a spreadsheet row
+	FileName  string
+	Kind      string
+	Blocking  bool
+	Message   string
+}
+
+func NewValidateInstruction() *ValidateInstruction {
+	return &ValidateInstruction{
+		SrcDir:          "sources",
+		SourceLookup:    SourceLookupPath,
+		PreserveSubpath: true,
+		DuplicatePolicy: DuplicateError,
+	}
+}
+
+func (vi *ValidateInstruction) Validate(pkg *model.Pkg) (*[]ValidationIssue, error) {
+	zi := &ZipInstruction{SrcDir: vi.SrcDir, Source: vi.Source, SourceLookup: vi.SourceLookup,
+		PreserveSubpath: vi.PreserveSubpath}
+	err := zi.ensureSource()
+	if err != nil {
+		return nil, err
+	}
+	err = checkSourceLookup(vi.SourceLookup)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDuplicatePolicy(vi.DuplicatePolicy)
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]ValidationIssue, 0)
+	refIdRows := make(map[string]string)
+	requests := make([]model.Request, 0, len(pkg.Requests))
+	for _, req := range pkg.Requests {
+		if existing, ok := refIdRows[req.ID]; ok {
+			issues = append(issues, rowIssue(req, IssueDuplicateRefID, true,
+				fmt.Sprintf("RefID [%v] is used by %v already", req.ID, existing)))
+		} else {
+			refIdRows[req.ID] = rowLabel(req.SheetName, req.RowNumber)
+		}
+		if req.FileName == "" {
+			issues = append(issues, rowIssue(req, IssueEmptyFileName, true, "FileName is empty"))
+			continue
+		}
+		if msg := checkFileName(req.FileName); msg != "" {
+			issues = append(issues, rowIssue(req, IssueUnsafeFileName, true, "FileName "+msg))
+			continue
+		}
+		requests = append(requests, req)
+	}
+	// the rows package would zip, found by the source lookup, and with the duplicates deduped or renamed
+	requests, lookupIssues, err := zi.resolveSourceIssues(requests)
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, lookupIssues...)
+	requests, dupIssues := resolveDuplicates(vi.DuplicatePolicy, requests)
+	issues = append(issues, dupIssues...)
+	referenced := make(map[string]bool)
+	for _, req := range requests {
+		referenced[req.SourcePath] = true
+		detected, err2 := detectMimeType(zi.source(), req.SourcePath)
+		if err2 != nil {
+			issues = append(issues, rowIssue(req, IssueMissingFile, true, err2.Error()))
+			continue
+		}
+		if req.MimeType != "" && detected != "" && !strings.EqualFold(req.MimeType, detected) {
+			issues = append(issues, rowIssue(req, IssueMimeTypeMismatch, false,
+				fmt.Sprintf("MimeType is [%v], but the file looks like [%v]", req.MimeType, detected)))
+		}
+	}
+	paths, err := zi.source().List()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		if !referenced[p] {
+			issues = append(issues, ValidationIssue{FileName: p, Kind: IssueUnreferencedFile,
+				Message: "file is not referenced by any row"})
+		}
+	}
+	sortIssues(pkg.Requests, issues)
+	return &issues, nil
+}
+
+// sortIssues puts the issues in the order of their rows, sheet by sheet, and the ones of no row after them
+func sortIssues(requests []model.Request, issues []ValidationIssue) {
+	order := make(map[sheetRow]int, len(requests))
+	for i, req := range requests {
+		order[sheetRow{req.SheetName, req.RowNumber}] = i
+	}
+	position := func(issue ValidationIssue) int {
+		if issue.RowNumber == 0 {
+			return len(requests)
+		}
+		return order[sheetRow{issue.SheetName, issue.RowNumber}]
+	}
+	sort.SliceStable(issues, func(a, b int) bool {
+		return position(issues[a]) < position(issues[b])
+	})
+}
+
+func rowIssue(req model.Request, kind string, blocking bool, msg string) ValidationIssue {
+	return ValidationIssue{SheetName: req.SheetName, RowNumber: req.RowNumber, FileName: req.FileName, Kind: kind,
+		Blocking: blocking, Message: msg}
+}
+
+// Label names the row of the issue in a message
+func (issue ValidationIssue) Label() string {
+	if issue.RowNumber == 0 {
+		return "[" + issue.FileName + "]"
+	}
+	return rowLabel(issue.SheetName, issue.RowNumber) + " [" + issue.FileName + "]"
+}
+
+// resolveSourceIssues resolves the sources like package does, and returns the rows it cannot resolve as issues
+// instead of failing, with the other rows resolved
+func (zi *ZipInstruction) resolveSourceIssues(requests []model.Request) ([]model.Request, []ValidationIssue, error) {
+	err := zi.resolveSources(requests)
+	var issues []ValidationIssue
+	var lookupErr *SourceLookupError
+	var unsafeErr *UnsafeFileNameError
+	if errors.As(err, &lookupErr) {
+		issues = lookupErr.Issues
+	} else if errors.As(err, &unsafeErr) {
+		issues = unsafeErr.Issues
+	} else if err != nil {
+		return nil, nil, err
+	}
+	failed := make(map[sheetRow]bool)
+	for _, issue := range issues {
+		failed[sheetRow{issue.SheetName, issue.RowNumber}] = true
+	}
+	ret := make([]model.Request, 0, len(requests))
+	for _, req := range requests {
+		if !failed[sheetRow{req.SheetName, req.RowNumber}] {
+			ret = append(ret, req)
+		}
+	}
+	return ret, issues, nil
+}
+
+// detectMimeType sniffs the file content, and falls back to its extension when the content is not recognized.
+// It returns empty when neither tells the type
+func detectMimeType(source SourceResolver, p string) (string, error) {
+	if _, err := source.Stat(p); err != nil {
+		return "", err
+	}
+	f, err := source.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer func(f io.ReadCloser) {
+		_ = f.Close()
+	}(f)
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	detected := http.DetectContentType(head[:n])
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") {
+		detected = mime.TypeByExtension(path.Ext(p))
+	}
+	if mediaType, _, err2 := mime.ParseMediaType(detected); err2 == nil {
+		return mediaType, nil
+	}
+	return "", nil
+}
+
+func HasBlockingIssue(issues *[]ValidationIssue) bool {
+	for _, issue := range *issues {
+		if issue.Blocking {
+			return true
+		}
+	}
+	return false
+}
+
+var validationReportHeaders = []string{"Sheet Name", "Row Number", "File Name", "Issue", "Blocking", "Message"}
+
+func validationReportRow(issue ValidationIssue) []string {
+	row := ""
+	if issue.RowNumber != 0 {
+		row = strconv.Itoa(issue.RowNumber)
+	}
+	return []string{issue.SheetName, row, issue.FileName, issue.Kind, strconv.FormatBool(issue.Blocking), issue.Message}
+}
+
+func (vi *ValidateInstruction) OutputExcel(issues *[]ValidationIssue, excel *excelize.File) error {
+	index, _ := excel.NewSheet("Validate")
+	colMap := colIndexToString(len(validationReportHeaders))
+	for i, header := range validationReportHeaders {
+		err := excel.SetCellValue("Validate", colMap[i]+"1", header)
+		if err != nil {
+			return err
+		}
+	}
+	for rowNum, issue := range *issues {
+		r := strconv.Itoa(rowNum + 2)
+		for i, val := range validationReportRow(issue) {
+			_ = excel.SetCellValue("Validate", colMap[i]+r, val)
+		}
+	}
+	excel.SetActiveSheet(index)
+	return nil
+}
+
+func (vi *ValidateInstruction) OutputCsv(issues *[]ValidationIssue, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(validationReportHeaders)
+	for _, issue := range *issues {
+		_ = cw.Write(validationReportRow(issue))
+	}
+	cw.Flush()
+	return cw.Error()
+}
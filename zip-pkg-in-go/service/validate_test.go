@@ -0,0 +1,195 @@
+package service
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestValidate(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, fn := range []string{"David-Passport.pdf", "Linda-DriverLicense.png"} {
+		data, _ := os.ReadFile("../testdata/pdfs/" + fn)
+		_ = os.WriteFile(srcDir+"/"+fn, data, 0644)
+	}
+	data, _ := os.ReadFile("../testdata/pdfs/Linda-DriverLicense.png")
+	_ = os.WriteFile(srcDir+"/dl-0001.pdf", data, 0644)
+	_ = os.WriteFile(srcDir+"/not-in-sheet.pdf", data, 0644)
+	pkg := &model.Pkg{
+		Requests: []model.Request{
+			{RowNumber: 1, ID: "1", FileName: "David-Passport.pdf", MimeType: "application/pdf"},
+			{RowNumber: 2, ID: "2", FileName: "Linda-DriverLicense.png", MimeType: "image/png"},
+			{RowNumber: 3, ID: "3", FileName: "dl-0001.pdf", MimeType: "application/pdf"},
+			{RowNumber: 4, ID: "3", FileName: "missing.pdf", MimeType: "application/pdf"},
+			{RowNumber: 5, ID: "5", FileName: "David-Passport.pdf", MimeType: "application/pdf"},
+			{RowNumber: 6, ID: "6", FileName: "", MimeType: "application/pdf"},
+			{RowNumber: 7, ID: "7", FileName: "../etc/passwd", MimeType: "text/plain"},
+		},
+	}
+	vi := NewValidateInstruction()
+	vi.SrcDir = srcDir
+	issues, err := vi.Validate(pkg)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	want := []struct {
+		rowNumber int
+		kind      string
+		blocking  bool
+	}{
+		{3, IssueMimeTypeMismatch, false},
+		{4, IssueDuplicateRefID, true},
+		{4, IssueMissingFile, true},
+		{5, IssueDuplicateFileName, true},
+		{6, IssueEmptyFileName, true},
+		{7, IssueUnsafeFileName, true},
+		{0, IssueUnreferencedFile, false},
+	}
+	if len(*issues) != len(want) {
+		t.Fatalf("got %v issues, want %v: %+v", len(*issues), len(want), *issues)
+	}
+	for i, issue := range *issues {
+		if issue.RowNumber != want[i].rowNumber || issue.Kind != want[i].kind || issue.Blocking != want[i].blocking {
+			t.Errorf("issue #%v got %+v, want %+v", i+1, issue, want[i])
+		}
+	}
+	if !HasBlockingIssue(issues) {
+		t.Errorf("expect blocking issues")
+	}
+}
+
+func TestValidateDuplicatePolicy(t *testing.T) {
+	srcDir := t.TempDir()
+	_ = os.WriteFile(srcDir+"/a.pdf", []byte("%PDF-1.4"), 0644)
+	pkg := &model.Pkg{
+		Requests: []model.Request{
+			{RowNumber: 1, ID: "1", FileName: "a.pdf"},
+			{RowNumber: 2, ID: "2", FileName: "a.pdf"},
+		},
+	}
+	for _, tt := range []struct {
+		policy       string
+		wantBlocking bool
+		wantMessage  string
+	}{
+		{DuplicateError, true, "is used by row 1 already"},
+		{DuplicateDedupe, false, "duplicate policy dedupe merges it into that row"},
+		{DuplicateRename, false, "duplicate policy rename renames it to a-2.pdf"},
+	} {
+		vi := NewValidateInstruction()
+		vi.SrcDir = srcDir
+		vi.DuplicatePolicy = tt.policy
+		issues, err := vi.Validate(pkg)
+		if err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if len(*issues) != 1 || (*issues)[0].Kind != IssueDuplicateFileName || (*issues)[0].RowNumber != 2 ||
+			HasBlockingIssue(issues) != tt.wantBlocking || !strings.Contains((*issues)[0].Message, tt.wantMessage) {
+			t.Errorf("policy %v: got %+v", tt.policy, *issues)
+		}
+	}
+	if pkg.Requests[1].FileName != "a.pdf" {
+		t.Errorf("Validate must not rename the rows of the pkg")
+	}
+}
+
+func TestValidateSourceLookup(t *testing.T) {
+	srcDir := t.TempDir()
+	for _, p := range []string{"2024/a.pdf", "2025/b.pdf", "2025/extra.pdf", "x/c.pdf", "y/c.pdf"} {
+		_ = os.MkdirAll(filepath.Dir(srcDir+"/"+p), 0755)
+		_ = os.WriteFile(srcDir+"/"+p, []byte("%PDF-1.4"), 0644)
+	}
+	pkg := &model.Pkg{
+		Requests: []model.Request{
+			{RowNumber: 1, ID: "1", FileName: "a.pdf"},
+			{RowNumber: 2, ID: "2", FileName: "2025/b.pdf"},
+			{RowNumber: 3, ID: "3", FileName: "c.pdf"},
+		},
+	}
+	tests := []struct {
+		lookup string
+		want   []string
+	}{
+		{SourceLookupPath, []string{"1 MissingFile a.pdf", "3 MissingFile c.pdf", "0 UnreferencedFile 2024/a.pdf",
+			"0 UnreferencedFile 2025/extra.pdf", "0 UnreferencedFile x/c.pdf", "0 UnreferencedFile y/c.pdf"}},
+		{SourceLookupRecursive, []string{"3 AmbiguousFileName c.pdf", "0 UnreferencedFile 2025/extra.pdf",
+			"0 UnreferencedFile x/c.pdf", "0 UnreferencedFile y/c.pdf"}},
+	}
+	for _, tt := range tests {
+		vi := NewValidateInstruction()
+		vi.SrcDir = srcDir
+		vi.SourceLookup = tt.lookup
+		issues, err := vi.Validate(pkg)
+		if err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		got := make([]string, 0)
+		for _, issue := range *issues {
+			got = append(got, strconv.Itoa(issue.RowNumber)+" "+issue.Kind+" "+issue.FileName)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookup %v: got %v, want %v", tt.lookup, got, tt.want)
+		}
+	}
+
+	// without the subpath, 2024/a.pdf and 2025/a.pdf end up as the same entry
+	_ = os.WriteFile(srcDir+"/2025/a.pdf", []byte("%PDF-1.4"), 0644)
+	pkg.Requests = []model.Request{
+		{RowNumber: 1, ID: "1", FileName: "2024/a.pdf"},
+		{RowNumber: 2, ID: "2", FileName: "2025/a.pdf"},
+	}
+	vi := NewValidateInstruction()
+	vi.SrcDir = srcDir
+	vi.PreserveSubpath = false
+	issues, err := vi.Validate(pkg)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(*issues) == 0 || (*issues)[0].RowNumber != 2 || (*issues)[0].Kind != IssueDuplicateFileName {
+		t.Errorf("expect row 2 as duplicate without subpath, got %+v", *issues)
+	}
+}
+
+func TestValidateFromSource(t *testing.T) {
+	zipFile := t.TempDir() + "/delivery.zip"
+	f, err := os.Create(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"a.pdf", "sub/b.pdf"} {
+		w, _ := zw.Create(name)
+		_, _ = w.Write([]byte("%PDF-1.4"))
+	}
+	_ = zw.Close()
+	_ = f.Close()
+	zipSource, err := NewZipArchiveSource(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = zipSource.Close()
+	}()
+	pkg := &model.Pkg{
+		Requests: []model.Request{
+			{RowNumber: 1, ID: "1", FileName: "a.pdf", MimeType: "application/pdf"},
+			{RowNumber: 2, ID: "2", FileName: "c.pdf"},
+		},
+	}
+	vi := NewValidateInstruction()
+	vi.SrcDir = "no-such-dir"
+	vi.Source = zipSource
+	issues, err := vi.Validate(pkg)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(*issues) != 2 || (*issues)[0].RowNumber != 2 || (*issues)[0].Kind != IssueMissingFile ||
+		(*issues)[1].FileName != "sub/b.pdf" || (*issues)[1].Kind != IssueUnreferencedFile {
+		t.Errorf("expect c.pdf missing and sub/b.pdf unreferenced in the zip, got %+v", *issues)
+	}
+}
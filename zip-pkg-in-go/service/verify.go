@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"zip-pkg-in-go/model"
+)
+
+// verifyArchive reads a written split back, and compares the CRC and size of every entry with its source file.
+// The metadata xml must parse into a package of the same requests
+func (zi *ZipInstruction) verifyArchive(split zipSplit, archiveFile string) error {
+	pending := make(map[string]*model.Request)
+	for i := range split.requests {
+		pending[split.requests[i].FileName] = &split.requests[i]
+	}
+	metaXmlFound := false
+	err := zi.walkArchive(archiveFile, func(name string, r io.Reader) error {
+		if name == zi.MetaXmlFileName {
+			metaXmlFound = true
+			return zi.verifyMetaXml(split, r)
+		}
+		req, ok := pending[name]
+		if !ok {
+			return fmt.Errorf("unexpected entry [%v]", name)
+		}
+		delete(pending, name)
+		crc, size, err := crc32Of(r)
+		if err != nil {
+			return fmt.Errorf("entry [%v] is not readable: %w", name, err)
+		}
+		f, err := zi.source().Open(sourcePath(req))
+		if err != nil {
+			return err
+		}
+		srcCrc, srcSize, err := crc32Of(f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+		if crc != srcCrc || size != srcSize {
+			return fmt.Errorf("entry [%v] has crc %08x and %v bytes, but the source has crc %08x and %v bytes", name, crc, size, srcCrc, srcSize)
+		}
+		return nil
+	})
+	if err == nil && !metaXmlFound {
+		err = fmt.Errorf("metadata xml [%v] is missing", zi.MetaXmlFileName)
+	}
+	if err == nil {
+		for name := range pending {
+			err = fmt.Errorf("entry [%v] is missing", name)
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("verify [%v] failed: %w", archiveFile, err)
+	}
+	fmt.Println("verified: ", archiveFile)
+	return nil
+}
+
+func (zi *ZipInstruction) verifyMetaXml(split zipSplit, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	pkg := &model.Pkg{}
+	if err = xml.Unmarshal(data, pkg); err != nil {
+		return fmt.Errorf("metadata xml does not parse: %w", err)
+	}
+	if len(pkg.Requests) != len(split.requests) || pkg.Trailer.RequestCount != len(split.requests) {
+		return fmt.Errorf("metadata xml has %v requests and request count %v, but the split has %v requests",
+			len(pkg.Requests), pkg.Trailer.RequestCount, len(split.requests))
+	}
+	for i, req := range pkg.Requests {
+		if req.ID != split.requests[i].ID || req.FileName != split.requests[i].FileName {
+			return fmt.Errorf("metadata xml request %v is [%v %v], but [%v %v] was expected", i+1,
+				req.ID, req.FileName, split.requests[i].ID, split.requests[i].FileName)
+		}
+	}
+	return nil
+}
+
+func crc32Of(r io.Reader) (uint32, int64, error) {
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, r)
+	return h.Sum32(), n, err
+}
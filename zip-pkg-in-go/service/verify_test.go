@@ -0,0 +1,39 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipVerify(t *testing.T) {
+	for _, format := range []string{ArchiveZip, ArchiveTarZst} {
+		t.Run(format, func(t *testing.T) {
+			srcDir := t.TempDir()
+			requests := makeSourceFiles(t, srcDir, []int{30000, 20000, 10})
+			zi := newTestZipInstruction(t, srcDir)
+			zi.ArchiveFormat = format
+			zi.Verify = true
+			if _, err := zi.Zip(&requests); err != nil {
+				t.Fatalf("Zip failed: %v", err)
+			}
+			archiveFile := zi.DstDir + "/package-1" + zi.archiveExt()
+			split := zipSplit{seq: 1, count: 1, requests: requests}
+			if err := zi.verifyArchive(split, archiveFile); err != nil {
+				t.Fatalf("verify failed: %v", err)
+			}
+			// the source changed after the split was written
+			if err := os.WriteFile(srcDir+"/f-2.pdf", []byte("changed"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := zi.verifyArchive(split, archiveFile); err == nil || !strings.Contains(err.Error(), "f-2.pdf") {
+				t.Errorf("expect mismatch of f-2.pdf, got %v", err)
+			}
+			split.requests = append(requests[:2:2], model.Request{ID: "9", FileName: "f-9.pdf"})
+			if err := zi.verifyArchive(split, archiveFile); err == nil {
+				t.Errorf("expect error for metadata xml of other requests")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestLimitEntryCount(t *testing.T) {
+	group := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		max  int
+		want [][]int
+	}{
+		{0, [][]int{{1, 2, 3, 4, 5}}},
+		{5, [][]int{{1, 2, 3, 4, 5}}},
+		{2, [][]int{{1, 2}, {3, 4}, {5}}},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.max), func(t *testing.T) {
+			if got := limitEntryCount(group, tt.max); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("limitEntryCount(%v) = %v, want %v", tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZip64WithMoreThan65535Entries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip zipping 70000 entries in short mode")
+	}
+	srcDir := t.TempDir()
+	source := makeSourceFiles(t, srcDir, []int{1})[0]
+	requests := make([]model.Request, 70000)
+	// every row zips the same source file under an entry name of its own
+	for i := range requests {
+		requests[i] = source
+		requests[i].RowNumber = i + 1
+		requests[i].ID = strconv.Itoa(i + 1)
+		requests[i].SourcePath = source.FileName
+		requests[i].FileName = "e-" + strconv.Itoa(i+1) + ".pdf"
+	}
+	for _, zip64 := range []bool{true, false} {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.MaxSize = 6 * 1024 * 1024 * 1024
+		zi.ChecksumAlgorithm = ChecksumNone
+		zi.Compression.Method = zip.Store
+		zi.Zip64 = zip64
+		summary, err := zi.Zip(&requests)
+		if err != nil {
+			t.Fatalf("Zip with zip64 %v failed: %v", zip64, err)
+		}
+		wantCounts := []int{70001}
+		if !zip64 {
+			wantCounts = []int{zip32MaxEntries + 1, 70000 - zip32MaxEntries + 1}
+		}
+		if summary.SplitCount != len(wantCounts) {
+			t.Fatalf("zip64 %v got %v splits, want %v", zip64, summary.SplitCount, len(wantCounts))
+		}
+		for i, want := range wantCounts {
+			zipFile := zi.DstDir + "/package-" + strconv.Itoa(i+1) + ".zip"
+			r, err := zip.OpenReader(zipFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.File) != want {
+				t.Errorf("zip64 %v split %v got %v entries, want %v", zip64, i+1, len(r.File), want)
+			}
+			_ = r.Close()
+			if pkg := readMetaXml(t, zipFile, zi.MetaXmlFileName); pkg.Trailer.RequestCount != want-1 {
+				t.Errorf("zip64 %v split %v has request count %v, want %v", zip64, i+1, pkg.Trailer.RequestCount, want-1)
+			}
+			// zip64 end of central directory record signature
+			hasZip64 := bytes.Contains(readFile(t, zipFile), []byte("PK\x06\x06"))
+			if hasZip64 != (want > 0xFFFF) {
+				t.Errorf("zip64 %v split %v has zip64 record %v", zip64, i+1, hasZip64)
+			}
+		}
+	}
+}
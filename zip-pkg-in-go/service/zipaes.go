@@ -0,0 +1,371 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// WinZip AES encryption, see https://www.winzip.com/en/support/aes-encryption/
+// Entries are written as AE-1, which keeps the CRC of the plain content, so that it can still be verified
+const (
+	EncryptionNone   = "none"
+	EncryptionAES256 = "aes-256"
+
+	zipMethodAES        = 99
+	zipFlagEncrypted    = 0x1
+	zipFlagDescriptor   = 0x8
+	zipFlagUTF8         = 0x800
+	zipVersionAES       = 51
+	aesExtraID          = 0x9901
+	aesExtraLen         = 11
+	aesVendorAE1        = 1
+	aesStrength256      = 3
+	aesKeyLen           = 32
+	aesSaltLen          = 16
+	aesVerifierLen      = 2
+	aesAuthCodeLen      = 10
+	aesPbkdf2Iterations = 1000
+	aesEntryOverhead    = aesSaltLen + aesVerifierLen + aesAuthCodeLen + 2*aesExtraLen
+)
+
+// ResolvePassword reads the zip password from an environment variable, or from the first line of a key file.
+// The password is never taken from the spreadsheet
+func ResolvePassword(envName string, keyFile string) (string, error) {
+	if envName != "" {
+		if password := os.Getenv(envName); password != "" {
+			return password, nil
+		}
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", err
+		}
+		if password := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0]); password != "" {
+			return password, nil
+		}
+	}
+	return "", errors.New("no zip password found in environment variable [" + envName + "] or key file [" + keyFile + "]")
+}
+
+func checkEncryption(encryption string, password string) error {
+	switch encryption {
+	case EncryptionNone:
+		return nil
+	case EncryptionAES256:
+		if password == "" {
+			return errors.New("encryption " + encryption + " needs a password")
+		}
+		return nil
+	}
+	return errors.New("unknown encryption [" + encryption + "]")
+}
+
+func deriveAesKeys(password string, salt []byte) (encKey []byte, authKey []byte, verifier []byte) {
+	keys := pbkdf2.Key([]byte(password), salt, aesPbkdf2Iterations, 2*aesKeyLen+aesVerifierLen, sha1.New)
+	return keys[:aesKeyLen], keys[aesKeyLen : 2*aesKeyLen], keys[2*aesKeyLen:]
+}
+
+func aesExtra(method uint16) []byte {
+	buf := make([]byte, aesExtraLen)
+	binary.LittleEndian.PutUint16(buf[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(buf[2:], aesExtraLen-4)
+	binary.LittleEndian.PutUint16(buf[4:], aesVendorAE1)
+	copy(buf[6:], "AE")
+	buf[8] = aesStrength256
+	binary.LittleEndian.PutUint16(buf[9:], method)
+	return buf
+}
+
+// winZipCtr is AES in counter mode with a little endian counter starting from 1, which differs from cipher.NewCTR
+type winZipCtr struct {
+	block     cipher.Block
+	counter   uint64
+	keystream [aes.BlockSize]byte
+	pos       int
+}
+
+func newWinZipCtr(key []byte) (*winZipCtr, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &winZipCtr{block: block, pos: aes.BlockSize}, nil
+}
+
+func (c *winZipCtr) xorKeyStream(dst []byte, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			c.counter++
+			var ctr [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(ctr[:], c.counter)
+			c.block.Encrypt(c.keystream[:], ctr[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.keystream[c.pos]
+		c.pos++
+	}
+}
+
+// aesEntryWriter compresses, encrypts and authenticates an entry written by CreateRaw,
+// and fills in its sizes and CRC on close, before archive/zip writes its data descriptor
+type aesEntryWriter struct {
+	fh         *zip.FileHeader
+	raw        io.Writer
+	comp       io.WriteCloser
+	ctr        *winZipCtr
+	mac        hash.Hash
+	crc        hash.Hash32
+	rawCount   int64
+	cipherSize int64
+	buf        []byte
+}
+
+func createAesEntry(zw *zip.Writer, name string, method uint16, cp *CompressionPolicy, password string) (io.WriteCloser, error) {
+	fh := aesFileHeader(name, method)
+	raw, err := zw.CreateRaw(fh)
+	if err != nil {
+		return nil, err
+	}
+	return newAesEntryWriter(fh, raw, method, cp, password)
+}
+
+func aesFileHeader(name string, method uint16) *zip.FileHeader {
+	fh := &zip.FileHeader{
+		Name:           name,
+		Method:         zipMethodAES,
+		Flags:          zipFlagEncrypted | zipFlagDescriptor,
+		CreatorVersion: zipVersionAES,
+		ReaderVersion:  zipVersionAES,
+		Extra:          aesExtra(method),
+	}
+	if !isASCII(name) {
+		fh.Flags |= zipFlagUTF8
+	}
+	return fh
+}
+
+// newAesEntryWriter writes the entry of fh into raw, which is either what CreateRaw returned, or a spool
+// to be copied into the zip later
+func newAesEntryWriter(fh *zip.FileHeader, raw io.Writer, method uint16, cp *CompressionPolicy, password string) (io.WriteCloser, error) {
+	salt := make([]byte, aesSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encKey, authKey, verifier := deriveAesKeys(password, salt)
+	if _, err := raw.Write(append(salt, verifier...)); err != nil {
+		return nil, err
+	}
+	ctr, err := newWinZipCtr(encKey)
+	if err != nil {
+		return nil, err
+	}
+	w := &aesEntryWriter{
+		fh:  fh,
+		raw: raw,
+		ctr: ctr,
+		mac: hmac.New(sha1.New, authKey),
+		crc: crc32.NewIEEE(),
+	}
+	w.comp = nopWriteCloser{&aesCipherWriter{w}}
+	if method == zip.Deflate {
+		w.comp = cp.newDeflater(&aesCipherWriter{w})
+	}
+	return w, nil
+}
+
+func (w *aesEntryWriter) Write(p []byte) (int, error) {
+	w.rawCount += int64(len(p))
+	w.crc.Write(p)
+	return w.comp.Write(p)
+}
+
+func (w *aesEntryWriter) Close() error {
+	if err := w.comp.Close(); err != nil {
+		return err
+	}
+	if _, err := w.raw.Write(w.mac.Sum(nil)[:aesAuthCodeLen]); err != nil {
+		return err
+	}
+	setEntrySizes(w.fh, w.crc.Sum32(), aesSaltLen+aesVerifierLen+w.cipherSize+aesAuthCodeLen, w.rawCount)
+	return nil
+}
+
+// setEntrySizes fills in the CRC and sizes of an entry written by CreateRaw, which go into its data descriptor
+func setEntrySizes(fh *zip.FileHeader, crc uint32, compressedSize int64, size int64) {
+	fh.CRC32 = crc
+	fh.CompressedSize64 = uint64(compressedSize)
+	fh.UncompressedSize64 = uint64(size)
+	if fh.CompressedSize64 > 0xFFFFFFFF || fh.UncompressedSize64 > 0xFFFFFFFF {
+		fh.CompressedSize = 0xFFFFFFFF
+		fh.UncompressedSize = 0xFFFFFFFF
+	} else {
+		fh.CompressedSize = uint32(fh.CompressedSize64)
+		fh.UncompressedSize = uint32(fh.UncompressedSize64)
+	}
+}
+
+// aesCipherWriter takes compressed bytes, and writes them encrypted
+type aesCipherWriter struct {
+	w *aesEntryWriter
+}
+
+func (cw *aesCipherWriter) Write(p []byte) (int, error) {
+	w := cw.w
+	if cap(w.buf) < len(p) {
+		w.buf = make([]byte, len(p))
+	}
+	buf := w.buf[:len(p)]
+	w.ctr.xorKeyStream(buf, p)
+	w.mac.Write(buf)
+	w.cipherSize += int64(len(buf))
+	return w.raw.Write(buf)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// openAesEntry decrypts an AES entry, it fails on wrong password, and on a wrong auth code or CRC at the end
+func openAesEntry(f *zip.File, password string) (io.ReadCloser, error) {
+	method, ok := aesActualMethod(f.Extra)
+	if f.Method != zipMethodAES || !ok {
+		return nil, errors.New("[" + f.Name + "] is not AES encrypted")
+	}
+	if f.CompressedSize64 < aesSaltLen+aesVerifierLen+aesAuthCodeLen {
+		return nil, errors.New("[" + f.Name + "] is too short to be AES encrypted")
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, aesSaltLen+aesVerifierLen)
+	if _, err = io.ReadFull(raw, head); err != nil {
+		return nil, err
+	}
+	encKey, authKey, verifier := deriveAesKeys(password, head[:aesSaltLen])
+	if !bytes.Equal(verifier, head[aesSaltLen:]) {
+		return nil, errors.New("wrong password for [" + f.Name + "]")
+	}
+	ctr, err := newWinZipCtr(encKey)
+	if err != nil {
+		return nil, err
+	}
+	r := &aesEntryReader{
+		name:      f.Name,
+		raw:       raw,
+		cipherR:   io.LimitReader(raw, int64(f.CompressedSize64)-aesSaltLen-aesVerifierLen-aesAuthCodeLen),
+		ctr:       ctr,
+		mac:       hmac.New(sha1.New, authKey),
+		crc:       crc32.NewIEEE(),
+		wantCrc32: f.CRC32,
+	}
+	r.plain = &aesCipherReader{r}
+	if method == zip.Deflate {
+		r.plain = flate.NewReader(&aesCipherReader{r})
+	} else if method != zip.Store {
+		return nil, zip.ErrAlgorithm
+	}
+	return r, nil
+}
+
+func aesActualMethod(extra []byte) (uint16, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == aesExtraID && size == aesExtraLen-4 {
+			return binary.LittleEndian.Uint16(extra[9:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, false
+}
+
+type aesEntryReader struct {
+	name      string
+	raw       io.Reader
+	cipherR   io.Reader
+	plain     io.Reader
+	ctr       *winZipCtr
+	mac       hash.Hash
+	crc       hash.Hash32
+	wantCrc32 uint32
+}
+
+func (r *aesEntryReader) Read(p []byte) (int, error) {
+	n, err := r.plain.Read(p)
+	r.crc.Write(p[:n])
+	if err == io.EOF {
+		if verr := r.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func (r *aesEntryReader) verify() error {
+	// drain what the decompressor did not need, so that the auth code covers all the cipher text
+	if _, err := io.Copy(io.Discard, &aesCipherReader{r}); err != nil {
+		return err
+	}
+	authCode := make([]byte, aesAuthCodeLen)
+	if _, err := io.ReadFull(r.raw, authCode); err != nil {
+		return err
+	}
+	if !hmac.Equal(authCode, r.mac.Sum(nil)[:aesAuthCodeLen]) {
+		return errors.New("auth code mismatch for [" + r.name + "]")
+	}
+	if r.crc.Sum32() != r.wantCrc32 {
+		return zip.ErrChecksum
+	}
+	return nil
+}
+
+func (r *aesEntryReader) Close() error {
+	if c, ok := r.plain.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type aesCipherReader struct {
+	r *aesEntryReader
+}
+
+func (cr *aesCipherReader) Read(p []byte) (int, error) {
+	n, err := cr.r.cipherR.Read(p)
+	cr.r.mac.Write(p[:n])
+	cr.r.ctr.xorKeyStream(p[:n], p[:n])
+	return n, err
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"testing"
+	"zip-pkg-in-go/model"
+)
+
+func TestZipWithAesEncryption(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 70000, 10})
+	requests[1].MimeType = "image/png"
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MaxSize = 200 * 1024
+	zi.Compression.Overrides = map[string]uint16{"image/png": zip.Store}
+	zi.Encryption = EncryptionAES256
+	zi.EncryptionPassword = "s3cret"
+	if _, err := zi.Zip(&requests); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	r, err := zip.OpenReader(zi.DstDir + "/package-1.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	if len(r.File) != 4 {
+		t.Fatalf("expect 4 entries, got %v", len(r.File))
+	}
+	for i, f := range r.File {
+		if f.Method != zipMethodAES || f.Flags&zipFlagEncrypted == 0 {
+			t.Errorf("%v is not encrypted", f.Name)
+		}
+		rc, err := openAesEntry(f, "s3cret")
+		if err != nil {
+			t.Fatalf("open %v failed: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("read %v failed: %v", f.Name, err)
+		}
+		if i < len(requests) {
+			if !bytes.Equal(data, readFile(t, srcDir+"/"+requests[i].FileName)) {
+				t.Errorf("%v decrypted content differs from source", f.Name)
+			}
+			continue
+		}
+		pkg := &model.Pkg{}
+		if err = xml.Unmarshal(data, pkg); err != nil || len(pkg.Requests) != 3 {
+			t.Errorf("meta xml does not decrypt into 3 requests: %v", err)
+		}
+	}
+	if _, err = openAesEntry(r.File[0], "wrong"); err == nil {
+		t.Errorf("expect error for wrong password")
+	}
+	if method, _ := aesActualMethod(r.File[1].Extra); method != zip.Store {
+		t.Errorf("expect stored png inside encryption, got method %v", method)
+	}
+}
+
+func TestZipEncryptionNeedsPassword(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10})
+	zi := newTestZipInstruction(t, srcDir)
+	zi.Encryption = EncryptionAES256
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Errorf("expect error without password")
+	}
+}
+
+func TestResolvePassword(t *testing.T) {
+	t.Setenv("ZIP_PKG_TEST_PASSWORD", "from-env")
+	keyFile := t.TempDir() + "/zip.key"
+	if err := os.WriteFile(keyFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := ResolvePassword("ZIP_PKG_TEST_PASSWORD", keyFile); err != nil || password != "from-env" {
+		t.Errorf("got %v %v, want from-env", password, err)
+	}
+	if password, err := ResolvePassword("ZIP_PKG_TEST_UNSET", keyFile); err != nil || password != "from-file" {
+		t.Errorf("got %v %v, want from-file", password, err)
+	}
+	if _, err := ResolvePassword("ZIP_PKG_TEST_UNSET", ""); err == nil {
+		t.Errorf("expect error without any password source")
+	}
+}
+
+func TestZipEncryptionRejectsUnzip(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10})
+	zi := newTestZipInstruction(t, srcDir)
+	zi.Unzip = true
+	zi.Encryption = EncryptionAES256
+	zi.EncryptionPassword = "s3cret"
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Errorf("expect error for encryption with unzip")
+	}
+	if names := dirEntryNames(t, zi.DstDir); len(names) != 0 {
+		t.Errorf("expect no plain files written, got %v", names)
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"zip-pkg-in-go/model"
+)
+
+// sizes below are upper bounds of what archive/zip writes, always assuming zip64 records,
+// so that the planned size of a split is never smaller than the zip on disk
+const (
+	zipLocalHeaderLen       = 30
+	zipDataDescriptorLen    = 24
+	zipCentralHeaderLen     = 46
+	zipCentralZip64ExtraLen = 28
+	zipEndOfCentralDirLen   = 22 + 56 + 20 // end of central directory, zip64 end record and locator
+	zipDeflateLevel         = 5            // the level archive/zip uses for its default deflate compressor
+	xmlEnvelopeSlack        = 64
+	zip32MaxSize            = 0xFFFFFFFF - 1 // every offset and size fits in 32 bits, so no zip64 record is needed
+	zip32MaxEntries         = 0xFFFF - 2     // archive/zip turns on zip64 at 0xFFFF records, and the metadata xml takes one
+	tarBlockLen             = 512
+	tarEndLen               = 2 * tarBlockLen
+	tarPaxSlack             = 64 // the pax records besides the name, e.g. size of a file over 8GB
+	streamSlack             = 64 // gzip header and trailer, or zstd frame header and checksum, and the final block
+)
+
+// effectiveMaxSize is max size, limited to what a zip without zip64 records holds when zip64 is turned off
+func (zi *ZipInstruction) effectiveMaxSize() int64 {
+	if !zi.isTar() && !zi.Zip64 && zi.MaxSize > zip32MaxSize {
+		return zip32MaxSize
+	}
+	return zi.MaxSize
+}
+
+// maxRequestsPerSplit is 0 for no limit
+func (zi *ZipInstruction) maxRequestsPerSplit() int {
+	if zi.isTar() || zi.Zip64 {
+		return 0
+	}
+	return zip32MaxEntries
+}
+
+// limitEntryCount cuts a group into groups of at most max entries, each one still fits since it is smaller
+func limitEntryCount(group []int, max int) [][]int {
+	ret := make([][]int, 0, 1)
+	for max > 0 && len(group) > max {
+		ret = append(ret, group[:max])
+		group = group[max:]
+	}
+	return append(ret, group)
+}
+
+// zipEntryOverhead is what an entry takes besides its compressed data, an encrypted entry also has salt, verifier and auth code
+func (zi *ZipInstruction) zipEntryOverhead(name string) int64 {
+	overhead := int64(zipLocalHeaderLen + zipDataDescriptorLen + zipCentralHeaderLen + zipCentralZip64ExtraLen + 2*len(name))
+	if zi.Encryption == EncryptionAES256 {
+		overhead += aesEntryOverhead
+	}
+	return overhead
+}
+
+// tarEntryOverhead is the header of a tar entry, a pax header in case the name is too long, and the padding of the data
+func tarEntryOverhead(name string) int64 {
+	paxLen := int64(len(name)) + tarPaxSlack
+	return 2*tarBlockLen + (paxLen+tarBlockLen-1)/tarBlockLen*tarBlockLen + tarBlockLen - 1
+}
+
+// compressBound is the max compressed size of n bytes in a compressed stream, without the stream slack.
+// The bound of a whole stream is not more than the sum of the bounds of its parts, since blocks are rounded up
+func (zi *ZipInstruction) compressBound(n int64) int64 {
+	if zi.ArchiveFormat == ArchiveTarZst {
+		// incompressible data ends up in raw blocks of at most 128K each with a 3 bytes header
+		return n + (n+255)/256
+	}
+	return n + deflateBlockOverhead(n)
+}
+
+// deflateBound is the max deflated size of n bytes: incompressible data ends up in stored blocks
+// of at most 16K each with a 5 bytes header, plus a partial and an empty final block
+func deflateBound(n int64) int64 {
+	return n + deflateBlockOverhead(n) + 26
+}
+
+func deflateBlockOverhead(n int64) int64 {
+	return (5*n + 16383) / 16384
+}
+
+// splitFixedSize is the part of a split that does not depend on its requests: the metadata xml entry
+// without any request in it, and the end of central directory records
+func (zi *ZipInstruction) splitFixedSize() int64 {
+	envelope := &model.Pkg{
+		ID: "9999999999",
+		Header: model.PkgHeader{
+			SubmissionDate: "2006-01-02",
+			SubmissionTime: "15:04:05",
+			Source:         zi.SourceID,
+		},
+		Trailer: model.PkgTrailer{
+			RequestCount: math.MaxInt,
+		},
+	}
+	xmlBytes, _ := xml.MarshalIndent(envelope, "", "    ")
+	xmlSize := int64(len(xmlBytes)) + int64(len("\n    <Requests>\n    </Requests>")) + xmlEnvelopeSlack
+	if zi.isTar() {
+		return zi.compressBound(tarEndLen+tarEntryOverhead(zi.MetaXmlFileName)+xmlSize) + streamSlack
+	}
+	return zipEndOfCentralDirLen + zi.zipEntryOverhead(zi.MetaXmlFileName) + deflateBound(xmlSize)
+}
+
+// requestXmlSize is what a request adds to the compressed metadata xml
+func (zi *ZipInstruction) requestXmlSize(req *model.Request) int64 {
+	xmlBytes, _ := xml.MarshalIndent(req, "        ", "    ")
+	return zi.compressBound(int64(len(xmlBytes)) + 1)
+}
+
+// entrySize is the bound of what a request adds to a split: its archive entry and its part of the metadata xml
+func (zi *ZipInstruction) entrySize(req *model.Request, rawSize int64) int64 {
+	xmlSize := zi.requestXmlSize(zi.withChecksumPlaceholder(req, rawSize))
+	if zi.isTar() {
+		return zi.compressBound(tarEntryOverhead(req.FileName)+rawSize) + xmlSize
+	}
+	return zi.entryBound(req, rawSize) + xmlSize
+}
+
+// entryBound is the bound of the archive entry of a request in a measured split, where a tar entry is
+// a compressed stream of its own. A stored zip entry is its raw size
+func (zi *ZipInstruction) entryBound(req *model.Request, rawSize int64) int64 {
+	if zi.isTar() {
+		return zi.compressBound(tarEntryOverhead(req.FileName)+rawSize) + streamSlack
+	}
+	if zi.Compression.methodOf(req.FileName, req.MimeType) == zip.Store {
+		return zi.zipEntryOverhead(req.FileName) + rawSize
+	}
+	return zi.zipEntryOverhead(req.FileName) + deflateBound(rawSize)
+}
+
+// withChecksumPlaceholder copies the request with the size and checksum it will have in the metadata xml
+func (zi *ZipInstruction) withChecksumPlaceholder(req *model.Request, rawSize int64) *model.Request {
+	ret := *req
+	ret.FileSize = rawSize
+	if h := newChecksumHash(zi.ChecksumAlgorithm); h != nil {
+		ret.ChecksumAlgorithm = zi.ChecksumAlgorithm
+		ret.Checksum = strings.Repeat("0", 2*h.Size())
+	}
+	return &ret
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -1,26 +1,75 @@
 package service
 
 import (
-	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"zip-pkg-in-go/model"
 )
 
 type ZipInstruction struct {
-	SrcDir                string
-	DstDir                string
-	MaxSize               int64
+	SrcDir  string
+	DstDir  string
+	MaxSize int64
+	// TargetFileNamePattern names a split without extension, with placeholders in form of ${name} or ${name:arg}:
+	//   - ${date:yyyy-MM-dd_HHmmss} formats the run time with yyyy, yy, MM, dd, HH, mm, ss and SSS,
+	//     and a layout of these letters only can go without date:, e.g. ${yyMMddHHmmssSSS}
+	//   - ${splitSeq}, ${splitCount} and ${requestCount} of the split, zero padded to a width with e.g. ${splitSeq:03}
+	//   - ${sourceId}, ${sheetName}, ${excelName} without extension, and ${runId}, a random UUID of the run
 	TargetFileNamePattern string
 	Unzip                 bool
+	UnzipMode             string
 	SourceID              string
 	MetaXmlFileName       string
+	Workers               int
+	SplitStrategy         string
+	MeasureCompressed     bool // fill splits one by one by the compressed size of every entry, instead of planning them
+	OversizePolicy        string
+	RejectsFileName       string
+	KeepPartial           bool
+	ChecksumAlgorithm     string
+	Sidecar               bool
+	Compression           CompressionPolicy
+	Zip64                 bool
+	Encryption            string
+	EncryptionPassword    string
+	ArchiveFormat         string
+	Verify                bool
+	SourceLookup          string
+	PreserveSubpath       bool
+	Source                SourceResolver // SrcDir is used when there is none
+	DuplicatePolicy       string
+	SheetName             string // for ${sheetName} of TargetFileNamePattern
+	ExcelName             string // for ${excelName}, without extension
+	RunID                 string // for ${runId}, a random UUID when empty
+}
+
+type ZipSummary struct {
+	SplitCount    int
+	RequestCount  int
+	IsolatedCount int
+	Rejects       []OversizeFile
+	RejectsFile   string // in the output dir, when there are rejects
+}
+
+type zipSplit struct {
+	seq      int
+	fileName string
+	count    int
+	requests []model.Request
+	oversize bool
 }
 
 func NewZipInstruction() *ZipInstruction {
@@ -30,94 +79,480 @@ func NewZipInstruction() *ZipInstruction {
 		MaxSize:               980 * 1024 * 1024,
 		TargetFileNamePattern: "package-${yyMMddHHmmssSSS}-${splitSeq}",
 		Unzip:                 true,
+		UnzipMode:             UnzipCopy,
 		SourceID:              "0086",
 		MetaXmlFileName:       "package-metadata.xml",
+		Workers:               runtime.NumCPU(),
+		SplitStrategy:         SplitSequential,
+		OversizePolicy:        OversizeFail,
+		RejectsFileName:       "package-rejects.csv",
+		ChecksumAlgorithm:     ChecksumSHA256,
+		Compression:           NewCompressionPolicy(),
+		Zip64:                 true,
+		Encryption:            EncryptionNone,
+		ArchiveFormat:         ArchiveZip,
+		SourceLookup:          SourceLookupPath,
+		PreserveSubpath:       true,
+		DuplicatePolicy:       DuplicateError,
+	}
+}
+
+func (zi *ZipInstruction) Zip(requests *[]model.Request) (*ZipSummary, error) {
+	err := zi.ensureSource()
+	if err != nil {
+		return nil, err
+	}
+	err = zi.checkOptions()
+	if err != nil {
+		return nil, err
+	}
+	if zi.RunID == "" {
+		zi.RunID = uuid.NewString()
+	}
+	err = zi.resolveSources(*requests)
+	if err != nil {
+		return nil, err
+	}
+	*requests, err = zi.applyDuplicatePolicy(*requests)
+	if err != nil {
+		return nil, err
+	}
+	if zi.MeasureCompressed {
+		return zi.zipMeasured(*requests)
+	}
+	splits, rejects, err := zi.planSplits(*requests)
+	if err != nil {
+		return nil, err
+	}
+	stagingDir, err := zi.newStagingDir()
+	if err != nil {
+		return nil, err
+	}
+	manifest := newRunManifest(stagingDir, zi.ArchiveFormat, zi.RunID, splits, rejects)
+	err = manifest.save()
+	if err != nil {
+		zi.abortStagingDir(stagingDir)
+		return nil, err
 	}
+	return zi.runSplits(manifest, splits, rejects)
 }
 
-func (zi *ZipInstruction) Zip(requests *[]model.Request) error {
-	err := ensureDir(zi.SrcDir)
+// Resume continues a failed run from its run manifest: splits verified by their checksum are kept,
+// and the others are rebuilt. The output goes to the dir of the manifest, where the failed run wrote to,
+// in the archive format of the failed run
+func (zi *ZipInstruction) Resume(requests *[]model.Request, manifestFile string) (*ZipSummary, error) {
+	err := zi.ensureSource()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := LoadRunManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.ArchiveFormat != "" {
+		zi.ArchiveFormat = manifest.ArchiveFormat
+	}
+	zi.MeasureCompressed = manifest.Measured
+	// the splits still to be named get the ${runId} of the failed run, a manifest of no run ID gets a new one
+	if manifest.RunID != "" {
+		zi.RunID = manifest.RunID
+	} else if zi.RunID == "" {
+		zi.RunID = uuid.NewString()
+	}
+	err = zi.checkOptions()
+	if err != nil {
+		return nil, err
+	}
+	err = zi.resolveSources(*requests)
+	if err != nil {
+		return nil, err
+	}
+	*requests, err = zi.applyDuplicatePolicy(*requests)
+	if err != nil {
+		return nil, err
+	}
+	splits, rejects, err := manifest.restorePlan(*requests)
+	if err != nil {
+		return nil, err
+	}
+	zi.DstDir = filepath.Dir(manifestFile)
+	err = os.MkdirAll(manifest.stagingDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Measured {
+		return zi.runMeasuredSplits(manifest, splits, *requests)
+	}
+	return zi.runSplits(manifest, splits, rejects)
+}
+
+func (zi *ZipInstruction) checkOptions() error {
+	err := checkOversizePolicy(zi.OversizePolicy)
+	if err != nil {
+		return err
+	}
+	zi.ChecksumAlgorithm, err = normalizeChecksumAlgorithm(zi.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	err = zi.Compression.check()
+	if err != nil {
+		return err
+	}
+	err = checkDuplicatePolicy(zi.DuplicatePolicy)
+	if err != nil {
+		return err
+	}
+	err = checkSourceLookup(zi.SourceLookup)
+	if err != nil {
+		return err
+	}
+	err = checkUnzipMode(zi.UnzipMode)
+	if err != nil {
+		return err
+	}
+	err = checkArchiveFormat(zi.ArchiveFormat)
 	if err != nil {
 		return err
 	}
-	size := int64(0)
-	splitSeq := 1
-	fromIdx := 0
-	for i, req := range *requests {
-		fileName := zi.SrcDir + "/" + req.FileName
-		info, err2 := os.Stat(fileName)
+	err = zi.checkTargetFileNamePattern()
+	if err != nil {
+		return err
+	}
+	if zi.isTar() && zi.Encryption != EncryptionNone {
+		return errors.New("encryption is only supported by archive format " + ArchiveZip)
+	}
+	// the unzip dir would hold the plain files next to the encrypted split
+	if zi.Unzip && zi.Encryption != EncryptionNone {
+		return errors.New("encryption cannot be used with unzip, turn unzip off")
+	}
+	return checkEncryption(zi.Encryption, zi.EncryptionPassword)
+}
+
+func (zi *ZipInstruction) runSplits(manifest *RunManifest, splits []zipSplit, rejects []OversizeFile) (*ZipSummary, error) {
+	stagingDir := manifest.stagingDir()
+	if len(rejects) > 0 && manifest.RejectsFile == "" {
+		manifest.RejectsFile = zi.uniqueRejectsFileName()
+	}
+	// an existing target would only fail the run at commit, after zipping everything
+	err := zi.checkStagedNames(splits, manifest.RejectsFile)
+	if err != nil {
+		return nil, err
+	}
+	todo := make([]zipSplit, 0, len(splits))
+	for i, split := range splits {
+		if manifest.verifiedDone(manifest.Splits[i], zi.archiveExt()) {
+			fmt.Println("verified: ", split.fileName)
+			continue
+		}
+		// leftovers of an unfinished split are rebuilt from scratch
+		zi.removeSplitOutput(stagingDir, split)
+		todo = append(todo, split)
+	}
+	err = runParallel(len(todo), zi.Workers, func(i int) error {
+		checksum, err2 := zi.zipFiles(todo[i], stagingDir)
 		if err2 != nil {
 			return err2
 		}
-		size += info.Size()
-		if size > zi.MaxSize {
-			zipErr := zi.zipFiles((*requests)[fromIdx:i], splitSeq)
-			if zipErr != nil {
-				return zipErr
-			}
-			splitSeq++
-			fromIdx = i
-			size = info.Size()
+		return manifest.markDone(todo[i].seq, checksum)
+	})
+	if err == nil && len(rejects) > 0 {
+		err = zi.writeRejectsReport(rejects, stagingDir, manifest.RejectsFile)
+	}
+	if err == nil {
+		err = commitStagingDir(stagingDir, zi.DstDir)
+	}
+	if err != nil {
+		zi.keepDoneSplits(manifest, splits)
+		fmt.Println("Resume with run manifest: ", manifest.Path())
+		return nil, err
+	}
+	_ = manifest.remove()
+	return zi.summarize(splits, rejects, manifest.RejectsFile), nil
+}
+
+// checkStagedNames fails when anything the run is going to commit exists in the output dir already
+func (zi *ZipInstruction) checkStagedNames(splits []zipSplit, rejectsFile string) error {
+	names := make([]string, 0, 4*len(splits)+1)
+	for _, split := range splits {
+		names = append(names, split.fileName+zi.archiveExt(), split.fileName+".d")
+		if zi.Sidecar {
+			names = append(names, zi.sidecarFileNames(split.fileName)...)
 		}
 	}
-	zipErr := zi.zipFiles((*requests)[fromIdx:], splitSeq)
-	if zipErr != nil {
-		return zipErr
+	if rejectsFile != "" {
+		names = append(names, rejectsFile)
+	}
+	for _, name := range names {
+		if _, err := os.Lstat(zi.DstDir + "/" + name); err == nil {
+			return errors.New("[" + zi.DstDir + "/" + name + "] exists already")
+		}
 	}
 	return nil
 }
 
-func (zi *ZipInstruction) zipFiles(requests []model.Request, seq int) error {
-	err := os.MkdirAll(zi.DstDir, 0755)
+func (zi *ZipInstruction) summarize(splits []zipSplit, rejects []OversizeFile, rejectsFile string) *ZipSummary {
+	summary := &ZipSummary{
+		SplitCount:  len(splits),
+		Rejects:     rejects,
+		RejectsFile: rejectsFile,
+	}
+	for _, split := range splits {
+		summary.RequestCount += len(split.requests)
+		if split.oversize {
+			summary.IsolatedCount++
+		}
+	}
+	zi.printSummary(summary)
+	return summary
+}
+
+func (zi *ZipInstruction) printSummary(summary *ZipSummary) {
+	fmt.Println()
+	fmt.Println("Zip result:")
+	fmt.Println("----------------------------------------------------------------")
+	fmt.Printf("Splits                : %v\n", summary.SplitCount)
+	fmt.Printf("Requests zipped       : %v\n", summary.RequestCount)
+	fmt.Printf("Oversize isolated     : %v\n", summary.IsolatedCount)
+	if len(summary.Rejects) > 0 {
+		fmt.Printf("Oversize skipped      : %v (see %v)\n", len(summary.Rejects), zi.DstDir+"/"+summary.RejectsFile)
+	} else {
+		fmt.Printf("Oversize skipped      : %v\n", 0)
+	}
+	fmt.Println("----------------------------------------------------------------")
+	fmt.Println()
+}
+
+// planSplits stats every source file and groups the requests into splits up front,
+// so that split sequences and target file names do not depend on how the splits are zipped later.
+// Requests which alone do not fit into a split are handled by the oversize policy
+func (zi *ZipInstruction) planSplits(requests []model.Request) ([]zipSplit, []OversizeFile, error) {
+	strategy, err := LookupSplitStrategy(zi.SplitStrategy)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	fn := zi.resolveTargetFileName(seq)
-	f, e := os.Create(zi.DstDir + "/" + fn + ".zip")
-	if e != nil {
-		return e
+	maxSize := zi.effectiveMaxSize()
+	capacity, err := zi.splitCapacity()
+	if err != nil {
+		return nil, nil, err
+	}
+	sizes := make([]int64, len(requests))
+	err = runParallel(len(requests), zi.Workers, func(i int) error {
+		rawSize, err2 := zi.source().Stat(sourcePath(&requests[i]))
+		if err2 != nil {
+			return err2
+		}
+		sizes[i] = zi.entrySize(&requests[i], rawSize)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	defer func(f *os.File) {
-		err := f.Close()
+	fitIndexes := make([]int, 0, len(requests))
+	fitSizes := make([]int64, 0, len(requests))
+	groups := make([][]int, 0)
+	oversizeGroups := make(map[int]bool)
+	oversizeFiles := make([]OversizeFile, 0)
+	for i, size := range sizes {
+		if size <= capacity {
+			fitIndexes = append(fitIndexes, i)
+			fitSizes = append(fitSizes, size)
+		} else if zi.OversizePolicy == OversizeIsolate {
+			if !zi.Zip64 && size > zip32MaxSize-zi.splitFixedSize() {
+				return nil, nil, fmt.Errorf("%v [%v] needs zip64 to be isolated, but zip64 is turned off",
+					rowLabel(requests[i].SheetName, requests[i].RowNumber), requests[i].FileName)
+			}
+			oversizeGroups[i] = true
+			groups = append(groups, []int{i})
+		} else {
+			oversizeFiles = append(oversizeFiles, OversizeFile{Request: &requests[i], Size: size})
+		}
+	}
+	if zi.OversizePolicy == OversizeFail && len(oversizeFiles) > 0 {
+		return nil, nil, &OversizeError{MaxSize: maxSize, Files: oversizeFiles}
+	}
+	for _, indexes := range strategy.Split(fitSizes, capacity) {
+		if len(indexes) == 0 {
+			continue
+		}
+		group := make([]int, 0, len(indexes))
+		for _, idx := range indexes {
+			group = append(group, fitIndexes[idx])
+		}
+		groups = append(groups, limitEntryCount(group, zi.maxRequestsPerSplit())...)
+	}
+	// isolated files take their place in spreadsheet order
+	sort.SliceStable(groups, func(a, b int) bool {
+		return groups[a][0] < groups[b][0]
+	})
+	tm := time.Now()
+	splits := make([]zipSplit, len(groups))
+	fileNames := make([]string, len(groups))
+	for i, group := range groups {
+		splitRequests := make([]model.Request, 0, len(group))
+		for _, idx := range group {
+			splitRequests = append(splitRequests, requests[idx])
+		}
+		fileName, err := zi.resolveTargetFileName(splitNameVars{tm: tm, seq: i + 1, count: len(groups), requestCount: len(group)})
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
-	}(f)
-	zipWriter := zip.NewWriter(f)
-	defer func(zipWriter *zip.Writer) {
-		err := zipWriter.Close()
+		fileNames[i] = fileName
+		splits[i] = zipSplit{
+			seq:      i + 1,
+			count:    len(groups),
+			requests: splitRequests,
+			oversize: oversizeGroups[group[0]],
+		}
+	}
+	for i, fileName := range zi.uniqueTargetFileNames(fileNames) {
+		splits[i].fileName = fileName
+	}
+	return splits, oversizeFiles, nil
+}
+
+// splitCapacity is what the requests of a split can take, besides its fixed size
+func (zi *ZipInstruction) splitCapacity() (int64, error) {
+	maxSize := zi.effectiveMaxSize()
+	capacity := maxSize - zi.splitFixedSize()
+	if capacity <= 0 {
+		return 0, fmt.Errorf("max size %v is too small to hold the metadata xml", maxSize)
+	}
+	return capacity, nil
+}
+
+// runParallel calls fn for 0 to count-1 with a bounded pool of workers. Once a call fails, no more calls
+// are started, and the error of the failed call with the lowest index is returned
+func runParallel(count int, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+	errs := make([]error, count)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				if failed.Load() {
+					continue
+				}
+				if err := fn(idx); err != nil {
+					errs[idx] = err
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	for idx := 0; idx < count; idx++ {
+		if failed.Load() {
+			break
+		}
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
-			panic(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// zipFiles writes the archive of a split and its unzip dir into the out dir, and returns the sha256 of the archive
+func (zi *ZipInstruction) zipFiles(split zipSplit, outDir string) (string, error) {
+	fn := split.fileName
+	zipFile := outDir + "/" + fn + zi.archiveExt()
+	f, e := os.Create(zipFile)
+	if e != nil {
+		return "", e
+	}
+	h := sha256.New()
+	zipWriter, err := zi.newArchiveWriter(io.MultiWriter(f, h))
+	if err == nil {
+		err = zi.doZipSplit(zipWriter, split, outDir+"/"+fn+".d")
+		if closeE := zipWriter.Close(); err == nil {
+			err = closeE
 		}
-	}(zipWriter)
-	unzipD := zi.DstDir + "/" + fn + ".d"
-	if zi.Unzip {
+	}
+	if closeE := f.Close(); err == nil {
+		err = closeE
+	}
+	if err == nil && !split.oversize {
+		err = zi.ensureWithinMaxSize(zipFile)
+	}
+	if err == nil {
+		err = zi.checkSplitArchive(split, zipFile, outDir+"/"+fn+".d")
+	}
+	if err != nil {
+		return "", err
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+	if zi.Sidecar {
+		err = zi.writeSidecars(split, outDir, checksum)
+		if err != nil {
+			return "", err
+		}
+	}
+	return checksum, nil
+}
+
+// checkSplitArchive reads a written split back to verify it, and to extract it into its unzip dir, if asked for
+func (zi *ZipInstruction) checkSplitArchive(split zipSplit, archiveFile string, unzipD string) error {
+	if zi.Verify {
+		if err := zi.verifyArchive(split, archiveFile); err != nil {
+			return err
+		}
+	}
+	if zi.Unzip && zi.UnzipMode == UnzipExtract {
+		return zi.extractArchive(archiveFile, unzipD)
+	}
+	return nil
+}
+
+func (zi *ZipInstruction) doZipSplit(zipWriter archiveWriter, split zipSplit, unzipD string) error {
+	requests := split.requests
+	mirror := zi.Unzip && zi.UnzipMode != UnzipExtract
+	if mirror {
 		mkDirE := os.Mkdir(unzipD, 0755)
 		if mkDirE != nil {
 			return mkDirE
 		}
 	}
-	for _, req := range requests {
-		zipE := zi.doZipFile(zipWriter, req)
+	for i, req := range requests {
+		zipE := zi.doZipFile(zipWriter, &requests[i])
 		if zipE != nil {
 			return zipE
 		}
-		if zi.Unzip {
-			cpE := zi.doCopySourceFile(req, unzipD)
+		if mirror {
+			cpE := zi.doMirrorSourceFile(req, unzipD)
 			if cpE != nil {
 				return cpE
 			}
 		}
 	}
+	return zi.doZipSplitMetaXml(zipWriter, split, unzipD)
+}
+
+// doZipSplitMetaXml writes the metadata xml of the requests of a split, which are zipped already
+func (zi *ZipInstruction) doZipSplitMetaXml(zipWriter archiveWriter, split zipSplit, unzipD string) error {
+	requests := split.requests
+	mirror := zi.Unzip && zi.UnzipMode != UnzipExtract
 	pkg := &model.Pkg{
-		ID: strconv.Itoa(seq),
+		ID: strconv.Itoa(split.seq),
 		Header: model.PkgHeader{
 			SubmissionDate: time.Now().Format("2006-01-02"),
 			SubmissionTime: time.Now().Format("15:04:05"),
 			Source:         zi.SourceID,
 		},
 		Trailer: model.PkgTrailer{
-			RequestCount: int16(len(requests)),
+			RequestCount: len(requests),
 		},
 		Requests: requests,
 	}
@@ -127,7 +562,7 @@ func (zi *ZipInstruction) zipFiles(requests []model.Request, seq int) error {
 	if zipE != nil {
 		return zipE
 	}
-	if zi.Unzip {
+	if mirror {
 		cpE := zi.doCopyMetaXml(&xmlContent, unzipD)
 		if cpE != nil {
 			return cpE
@@ -136,36 +571,67 @@ func (zi *ZipInstruction) zipFiles(requests []model.Request, seq int) error {
 	return nil
 }
 
-func (zi *ZipInstruction) doZipMetaXml(zw *zip.Writer, xmlStr *string) error {
-	w, we := zw.Create(zi.MetaXmlFileName)
+// ensureWithinMaxSize guards max size as the hard limit of the zip on disk, in case the planned size was wrong
+func (zi *ZipInstruction) ensureWithinMaxSize(zipFile string) error {
+	info, err := os.Stat(zipFile)
+	if err != nil {
+		return err
+	}
+	if info.Size() > zi.effectiveMaxSize() {
+		return fmt.Errorf("zip [%v] has %v bytes, exceeds max size %v", zipFile, info.Size(), zi.effectiveMaxSize())
+	}
+	return nil
+}
+
+func (zi *ZipInstruction) doZipMetaXml(zw archiveWriter, xmlStr *string) error {
+	w, we := zw.createEntry(zi.MetaXmlFileName, metaXmlMimeType, int64(len(*xmlStr)))
 	if we != nil {
 		return we
 	}
 	if _, err := io.WriteString(w, *xmlStr); err != nil {
 		return err
 	}
+	if err := w.Close(); err != nil {
+		return err
+	}
 	fmt.Println("zipped: ", zi.MetaXmlFileName)
 	return nil
 }
 
-func (zi *ZipInstruction) doZipFile(zw *zip.Writer, req model.Request) error {
-	f, e := os.Open(zi.SrcDir + "/" + req.FileName)
+// doZipFile fills in the file size and checksum of the request while streaming the file into the zip
+func (zi *ZipInstruction) doZipFile(zw archiveWriter, req *model.Request) error {
+	size, e := zi.source().Stat(sourcePath(req))
 	if e != nil {
 		return e
 	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			panic(err)
-		}
+	f, e := zi.source().Open(sourcePath(req))
+	if e != nil {
+		return e
+	}
+	defer func(f io.ReadCloser) {
+		_ = f.Close()
 	}(f)
-	w, we := zw.Create(req.FileName)
+	w, we := zw.createEntry(req.FileName, req.MimeType, size)
 	if we != nil {
 		return we
 	}
-	if _, err := io.Copy(w, f); err != nil {
+	h := newChecksumHash(zi.ChecksumAlgorithm)
+	var r io.Reader = f
+	if h != nil {
+		r = io.TeeReader(f, h)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
 		return err
 	}
+	req.FileSize = n
+	if h != nil {
+		req.ChecksumAlgorithm = zi.ChecksumAlgorithm
+		req.Checksum = hex.EncodeToString(h.Sum(nil))
+	}
 	fmt.Println("zipped: ", req.FileName)
 	return nil
 }
@@ -176,6 +642,7 @@ func (zi *ZipInstruction) doCopyMetaXml(xmlStr *string, dstDir string) error {
 		return we
 	}
 	if _, err := io.WriteString(w, *xmlStr); err != nil {
+		_ = w.Close()
 		return err
 	}
 	err := w.Close()
@@ -187,15 +654,12 @@ func (zi *ZipInstruction) doCopyMetaXml(xmlStr *string, dstDir string) error {
 }
 
 func (zi *ZipInstruction) doCopySourceFile(req model.Request, dstDir string) error {
-	f, e := os.Open(zi.SrcDir + "/" + req.FileName)
+	f, e := zi.source().Open(sourcePath(&req))
 	if e != nil {
 		return e
 	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			panic(err)
-		}
+	defer func(f io.ReadCloser) {
+		_ = f.Close()
 	}(f)
 
 	w, we := os.Create(dstDir + "/" + req.FileName)
@@ -203,6 +667,7 @@ func (zi *ZipInstruction) doCopySourceFile(req model.Request, dstDir string) err
 		return we
 	}
 	if _, err := io.Copy(w, f); err != nil {
+		_ = w.Close()
 		return err
 	}
 	err := w.Close()
@@ -213,12 +678,12 @@ func (zi *ZipInstruction) doCopySourceFile(req model.Request, dstDir string) err
 	return nil
 }
 
-func (zi *ZipInstruction) resolveTargetFileName(seq int) string {
-	tm := time.Now()
-	ts := fmt.Sprintf("%02d%02d%02d%02d%02d%02d%03d", tm.Year()%100, tm.Month(), tm.Day(), tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond()/1000000)
-	fileName := strings.ReplaceAll(zi.TargetFileNamePattern, "${yyMMddHHmmssSSS}", ts)
-	fileName = strings.ReplaceAll(fileName, "${splitSeq}", strconv.Itoa(seq))
-	return fileName
+// ensureSource checks the source dir, unless there is a source resolver
+func (zi *ZipInstruction) ensureSource() error {
+	if zi.Source != nil {
+		return nil
+	}
+	return ensureDir(zi.SrcDir)
 }
 
 func ensureDir(dir string) error {
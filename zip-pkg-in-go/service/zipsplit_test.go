@@ -1,9 +1,23 @@
 package service
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"zip-pkg-in-go/model"
 )
 
 func TestTimeFormat(t *testing.T) {
@@ -24,3 +38,379 @@ func TestTimeFormat(t *testing.T) {
 	fmt.Println("t2: ", t2)
 
 }
+
+func TestZipWithWorkersKeepsSerialOrder(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 30000, 30000, 30000, 30000, 30000, 30000})
+	entries := make([][][]string, 0)
+	for _, workers := range []int{1, 4} {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.MaxSize = 70000
+		zi.Workers = workers
+		if _, err := zi.Zip(&requests); err != nil {
+			t.Fatalf("Zip with %v workers failed: %v", workers, err)
+		}
+		entries = append(entries, readZipEntries(t, zi.DstDir, []string{"package-1", "package-2", "package-3", "package-4"}))
+	}
+	want := [][]string{
+		{"f-1.pdf", "f-2.pdf", "package-metadata.xml"},
+		{"f-3.pdf", "f-4.pdf", "package-metadata.xml"},
+		{"f-5.pdf", "f-6.pdf", "package-metadata.xml"},
+		{"f-7.pdf", "package-metadata.xml"},
+	}
+	for i, got := range entries {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("run #%v got %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestZipNeverExceedsMaxSize(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{10000, 7000, 12000, 3000, 9000, 10000})
+	rnd := rand.New(rand.NewSource(1))
+	for _, req := range requests {
+		// incompressible content is the worst case for the size bound
+		data := make([]byte, len(readFile(t, srcDir+"/"+req.FileName)))
+		rnd.Read(data)
+		if err := os.WriteFile(srcDir+"/"+req.FileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for maxSize := int64(14000); maxSize < 60000; maxSize += 997 {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.MaxSize = maxSize
+		if _, err := zi.Zip(&requests); err != nil {
+			t.Errorf("Zip with max size %v failed: %v", maxSize, err)
+		}
+	}
+}
+
+func TestZipMeasureCompressed(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 30000, 30000})
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MaxSize = 5000
+	zi.MeasureCompressed = true
+	if _, err := zi.Zip(&requests); err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	got := readZipEntries(t, zi.DstDir, []string{"package-1"})
+	want := [][]string{{"f-1.pdf", "f-2.pdf", "f-3.pdf", "package-metadata.xml"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestZipChecksumsInMetaXml(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{3000, 5000})
+	for _, algorithm := range []string{"sha-256", "MD5", "none"} {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.ChecksumAlgorithm = algorithm
+		if _, err := zi.Zip(&requests); err != nil {
+			t.Fatalf("Zip failed: %v", err)
+		}
+		pkg := readMetaXml(t, zi.DstDir+"/package-1.zip", zi.MetaXmlFileName)
+		for i, req := range pkg.Requests {
+			data := readFile(t, srcDir+"/"+req.FileName)
+			want := ""
+			if algorithm == "sha-256" {
+				sum := sha256.Sum256(data)
+				want = hex.EncodeToString(sum[:])
+			} else if algorithm == "MD5" {
+				sum := md5.Sum(data)
+				want = hex.EncodeToString(sum[:])
+			}
+			if req.Checksum != want || req.FileSize != int64(len(data)) {
+				t.Errorf("%v request #%v got checksum %v size %v, want %v %v", algorithm, i+1, req.Checksum, req.FileSize, want, len(data))
+			}
+		}
+		if requests[0].Checksum != "" {
+			t.Errorf("expect requests of the caller untouched")
+		}
+	}
+}
+
+func readMetaXml(t *testing.T, zipFile string, metaXmlFileName string) *model.Pkg {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	f, err := r.Open(metaXmlFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	pkg := &model.Pkg{}
+	if err = xml.Unmarshal(data, pkg); err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestZipOversizePolicies(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{3000, 90000, 3000, 80000, 3000})
+	newZi := func(policy string) *ZipInstruction {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.MaxSize = 50000
+		zi.OversizePolicy = policy
+		return zi
+	}
+
+	_, err := newZi(OversizeFail).Zip(&requests)
+	var oversizeErr *OversizeError
+	if !errors.As(err, &oversizeErr) || len(oversizeErr.Files) != 2 || oversizeErr.Files[0].Request.FileName != "f-2.pdf" || oversizeErr.Files[1].Request.FileName != "f-4.pdf" {
+		t.Errorf("expect oversize error listing f-2.pdf and f-4.pdf, got %v", err)
+	}
+
+	zi := newZi(OversizeIsolate)
+	summary, err := zi.Zip(&requests)
+	if err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	if summary.SplitCount != 3 || summary.IsolatedCount != 2 || summary.RequestCount != 5 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	got := readZipEntries(t, zi.DstDir, []string{"package-1", "package-2", "package-3"})
+	want := [][]string{
+		{"f-1.pdf", "f-3.pdf", "f-5.pdf", "package-metadata.xml"},
+		{"f-2.pdf", "package-metadata.xml"},
+		{"f-4.pdf", "package-metadata.xml"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	zi = newZi(OversizeSkip)
+	sheetRequests := append([]model.Request(nil), requests...)
+	for i := range sheetRequests {
+		sheetRequests[i].SheetName = "Sheet2"
+	}
+	summary, err = zi.Zip(&sheetRequests)
+	if err != nil {
+		t.Fatalf("Zip failed: %v", err)
+	}
+	if summary.SplitCount != 1 || len(summary.Rejects) != 2 || summary.RequestCount != 3 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	rejects := strings.Split(strings.TrimSpace(string(readFile(t, zi.DstDir+"/"+zi.RejectsFileName))), "\n")
+	if len(rejects) != 3 || !strings.HasPrefix(rejects[1], "Sheet2,2,2,f-2.pdf,") ||
+		!strings.HasPrefix(rejects[2], "Sheet2,4,4,f-4.pdf,") || !strings.HasSuffix(rejects[2], ",50000,larger than max size") {
+		t.Errorf("unexpected rejects report %v", rejects)
+	}
+
+	// a second run into the same dir gets a rejects report of its own
+	dstDir := zi.DstDir
+	zi = newZi(OversizeSkip)
+	zi.DstDir = dstDir
+	summary, err = zi.Zip(&requests)
+	if err != nil {
+		t.Fatalf("second Zip failed: %v", err)
+	}
+	if summary.RejectsFile != "package-rejects-2.csv" {
+		t.Errorf("got rejects report %v, want package-rejects-2.csv", summary.RejectsFile)
+	}
+
+	// the rejects report has the max size the splits were planned with, limited without zip64
+	zi.MaxSize = 2 * zip32MaxSize
+	zi.Zip64 = false
+	err = zi.writeRejectsReport(summary.Rejects, dstDir, "zip32-rejects.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejects = strings.Split(strings.TrimSpace(string(readFile(t, dstDir+"/zip32-rejects.csv"))), "\n")
+	if !strings.HasSuffix(rejects[1], ","+strconv.FormatInt(zip32MaxSize, 10)+",larger than max size") {
+		t.Errorf("unexpected rejects report %v", rejects)
+	}
+}
+
+func TestResumeChecksTargetsBeforeZipping(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 30000, 30000})
+	data := readFile(t, srcDir+"/f-3.pdf")
+	_ = os.Remove(srcDir + "/f-3.pdf")
+	_ = os.Mkdir(srcDir+"/f-3.pdf", 0755)
+	zi := newTestZipInstruction(t, srcDir)
+	zi.MaxSize = 40000
+	if _, err := zi.Zip(&requests); err == nil {
+		t.Fatalf("expect Zip to fail")
+	}
+	manifestFile := zi.DstDir + "/" + dirEntryNames(t, zi.DstDir)[1]
+	manifest, err := LoadRunManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(srcDir + "/f-3.pdf")
+	_ = os.WriteFile(srcDir+"/f-3.pdf", data, 0644)
+	_ = os.WriteFile(zi.DstDir+"/package-2.zip", nil, 0644)
+	_, err = newTestZipInstruction(t, srcDir).Resume(&requests, manifestFile)
+	if err == nil || !strings.Contains(err.Error(), "package-2.zip] exists already") {
+		t.Errorf("expect package-2.zip to exist already, got %v", err)
+	}
+	if _, err = os.Stat(manifest.stagingDir() + "/package-2.zip"); err == nil {
+		t.Errorf("expect package-2 not to be zipped")
+	}
+}
+
+func TestZipFailureLeavesNoPartialOutput(t *testing.T) {
+	srcDir := t.TempDir()
+	requests := makeSourceFiles(t, srcDir, []int{30000, 30000})
+	// a directory passes planning, but fails to be zipped
+	if err := os.Mkdir(srcDir+"/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	requests = append(requests, model.Request{RowNumber: 3, ID: "3", FileName: "sub"})
+	for _, keepPartial := range []bool{false, true} {
+		zi := newTestZipInstruction(t, srcDir)
+		zi.Unzip = true
+		zi.MaxSize = 40000
+		zi.KeepPartial = keepPartial
+		if _, err := zi.Zip(&requests); err == nil {
+			t.Fatalf("expect Zip to fail")
+		}
+		names := dirEntryNames(t, zi.DstDir)
+		if len(names) != 2 || !strings.HasPrefix(names[0], ".staging-") || !strings.HasPrefix(names[1], "run-manifest-") {
+			t.Fatalf("expect staging dir and run manifest in output dir, got %v", names)
+		}
+		manifest, err := LoadRunManifest(zi.DstDir + "/" + names[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the done split is kept for resume, the failed one leaves its partial output only when asked to
+		last := len(manifest.Splits)
+		if !manifest.Splits[0].Done || manifest.Splits[last-1].Done {
+			t.Fatalf("expect split 1 done and split %d not, got %+v", last, manifest.Splits)
+		}
+		hasDone, hasFailed := false, false
+		for _, name := range dirEntryNames(t, zi.DstDir+"/"+names[0]) {
+			hasDone = hasDone || strings.HasPrefix(name, "package-1.")
+			hasFailed = hasFailed || strings.HasPrefix(name, "package-"+strconv.Itoa(last)+".")
+		}
+		if !hasDone || hasFailed != keepPartial {
+			t.Errorf("keep partial %v: done split left %v, failed split left %v", keepPartial, hasDone, hasFailed)
+		}
+	}
+}
+
+func TestZipResume(t *testing.T) {
+	for _, keepPartial := range []bool{false, true} {
+		srcDir := t.TempDir()
+		requests := makeSourceFiles(t, srcDir, []int{30000, 30000, 30000})
+		data := readFile(t, srcDir+"/f-3.pdf")
+		_ = os.Remove(srcDir + "/f-3.pdf")
+		_ = os.Mkdir(srcDir+"/f-3.pdf", 0755)
+		zi := newTestZipInstruction(t, srcDir)
+		zi.Unzip = true
+		zi.MaxSize = 40000
+		zi.Workers = 1
+		zi.KeepPartial = keepPartial
+		if _, err := zi.Zip(&requests); err == nil {
+			t.Fatalf("expect Zip to fail")
+		}
+		manifestFile := zi.DstDir + "/" + dirEntryNames(t, zi.DstDir)[1]
+		manifest, err := LoadRunManifest(manifestFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Splits) != 2 || !manifest.Splits[0].Done || manifest.Splits[1].Done {
+			t.Errorf("expect split 1 done and split 2 not, got %+v", manifest.Splits)
+		}
+		stagingZip := zi.DstDir + "/" + manifest.StagingDir + "/package-1.zip"
+		stagingInfo, err := os.Stat(stagingZip)
+		if err != nil {
+			t.Fatalf("keep partial %v: expect the done split in the staging dir: %v", keepPartial, err)
+		}
+
+		_ = os.Remove(srcDir + "/f-3.pdf")
+		_ = os.WriteFile(srcDir+"/f-3.pdf", data, 0644)
+		zi2 := NewZipInstruction()
+		zi2.SrcDir = srcDir
+		zi2.MaxSize = 40000
+		summary, err := zi2.Resume(&requests, manifestFile)
+		if err != nil {
+			t.Fatalf("Resume failed: %v", err)
+		}
+		if summary.SplitCount != 2 || summary.RequestCount != 3 {
+			t.Errorf("unexpected summary %+v", summary)
+		}
+		names := dirEntryNames(t, zi.DstDir)
+		want := []string{"package-1.d", "package-1.zip", "package-2.d", "package-2.zip"}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("got %v, want %v", names, want)
+		}
+		// the verified split is moved, not rebuilt
+		info, _ := os.Stat(zi.DstDir + "/package-1.zip")
+		if !os.SameFile(info, stagingInfo) {
+			t.Errorf("keep partial %v: expect package-1.zip of the failed run to be kept", keepPartial)
+		}
+	}
+}
+
+func dirEntryNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func readFile(t *testing.T, fileName string) []byte {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func makeSourceFiles(t *testing.T, srcDir string, sizes []int) []model.Request {
+	requests := make([]model.Request, 0)
+	for i, size := range sizes {
+		fileName := "f-" + strconv.Itoa(i+1) + ".pdf"
+		if err := os.WriteFile(srcDir+"/"+fileName, bytes.Repeat([]byte{byte('a' + i)}, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, model.Request{
+			RowNumber: i + 1,
+			ID:        strconv.Itoa(i + 1),
+			FileName:  fileName,
+			MimeType:  "application/pdf",
+		})
+	}
+	return requests
+}
+
+// newTestZipInstruction zips srcDir into a temp dir without unzip dirs, naming the splits package-1, package-2 and so on
+func newTestZipInstruction(t *testing.T, srcDir string) *ZipInstruction {
+	zi := NewZipInstruction()
+	zi.SrcDir = srcDir
+	zi.DstDir = t.TempDir()
+	zi.Unzip = false
+	zi.TargetFileNamePattern = "package-${splitSeq}"
+	return zi
+}
+
+func readZipEntries(t *testing.T, dstDir string, fileNames []string) [][]string {
+	ret := make([][]string, 0)
+	for _, fn := range fileNames {
+		r, err := zip.OpenReader(dstDir + "/" + fn + ".zip")
+		if err != nil {
+			t.Fatalf("open %v failed: %v", fn, err)
+		}
+		names := make([]string, 0)
+		for _, f := range r.File {
+			names = append(names, f.Name)
+		}
+		_ = r.Close()
+		ret = append(ret, names)
+	}
+	return ret
+}
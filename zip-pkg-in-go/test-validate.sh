@@ -0,0 +1,14 @@
+mkdir tmp-src
+mkdir tmp-out
+cp testdata/pdfs/David-Passport.pdf      tmp-src/.
+cp testdata/pdfs/Linda-DriverLicense.png tmp-src/.
+cp testdata/pdfs/David-Passport.pdf      tmp-src/pp-0001.pdf
+cp testdata/pdfs/Linda-DriverLicense.png tmp-src/dl-0001.pdf
+
+go run main/main.go --excel  testdata/excel/pkg-test.xlsx \
+                    --sheet  Sheet1 \
+                    --config testdata/configs/test-pkg-in-400kb.properties \
+                    --out    tmp-out \
+                    validate \
+                    --pdf-dir tmp-src \
+                    --report  tmp-out/validate-result--Sheet1.csv